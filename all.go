@@ -54,6 +54,7 @@ func registerAll(m map[string]setupFunc, app *kingpin.Application, name string,
 	grpcBindAddr, grpcGracePeriod, grpcCert, grpcKey, grpcClientCA := extkingpin.RegisterGRPCFlags(cmd)
 	queryTimeout := extkingpin.ModelDuration(cmd.Flag("query.timeout", "Maximum time to process query by query node.").
 		Default("10s"))
+	labelValuesCacheTTL := extkingpin.ModelDuration(cmd.Flag("store.label-values-cache-ttl", "How long label values are cached before being recomputed from all blocks. 0s - disables the cache").Default("1m"))
 
 	m[name] = func(comp component.Component, g *run.Group, mux httpMux, probe prober.Probe, logger log.Logger, reg *prometheus.Registry, debugLogging bool) (prober.Probe, error) {
 		return runAll(
@@ -70,6 +71,7 @@ func registerAll(m map[string]setupFunc, app *kingpin.Application, name string,
 			reloaders,
 			int64(*maxMergeBatchSize),
 			*queryTimeout,
+			time.Duration(*labelValuesCacheTTL),
 			&grpcSettings{
 				grpcBindAddr:    *grpcBindAddr,
 				grpcGracePeriod: time.Duration(*grpcGracePeriod),
@@ -95,6 +97,7 @@ func runAll(
 	reloaders *configReloaders,
 	maxMergeBatchSize int64,
 	queryTimeout model.Duration,
+	labelValuesCacheTTL time.Duration,
 	srv *grpcSettings,
 ) (prober.Probe, error) {
 	db, err := tsdb.Open(
@@ -149,6 +152,7 @@ func runAll(
 		reg,
 		logger,
 		db,
+		labelValuesCacheTTL,
 		srv.grpcBindAddr,
 		srv.grpcGracePeriod,
 		srv.grpcCert,
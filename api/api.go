@@ -44,6 +44,7 @@ import (
 
 	"github.com/conprof/conprof/config"
 	"github.com/conprof/conprof/internal/pprof/measurement"
+	"github.com/conprof/conprof/pkg/requestid"
 	"github.com/conprof/conprof/scrape"
 )
 
@@ -83,6 +84,8 @@ type API struct {
 	queryRangeHist    prometheus.Histogram
 	mergeSizeHist     prometheus.Histogram
 	queryTimeout      time.Duration
+	maxMatchers       int
+	parseGate         parseGate
 
 	mu     sync.RWMutex
 	config *config.Config
@@ -165,6 +168,22 @@ func WithQueryTimeout(t time.Duration) Option {
 	}
 }
 
+// WithMaxMatchers limits the total number of label matchers a single read
+// request may use. Zero or less means unlimited.
+func WithMaxMatchers(n int) Option {
+	return func(a *API) {
+		a.maxMatchers = n
+	}
+}
+
+// WithMaxConcurrentParses limits how many profiles are parsed concurrently
+// across all requests. Zero or less means unlimited.
+func WithMaxConcurrentParses(n int) Option {
+	return func(a *API) {
+		a.parseGate = newParseGate(n)
+	}
+}
+
 // Routes returns a http.Handler containing all routes of the API so that it can be mounted into a mux.
 func (a *API) Routes() http.Handler {
 	r := httprouter.New()
@@ -178,6 +197,7 @@ func (a *API) Routes() http.Handler {
 		r.GET(path.Join(a.prefix, "/series"), instr("series", a.Series))
 		r.GET(path.Join(a.prefix, "/labels"), instr("label_names", a.LabelNames))
 		r.GET(path.Join(a.prefix, "/label/:name/values"), instr("label_values", a.LabelValues))
+		r.GET(path.Join(a.prefix, "/label_values"), instr("bulk_label_values", a.BulkLabelValues))
 	}
 	if a.config != nil {
 		r.GET(path.Join(a.prefix, "/status/config"), instr("config", a.Config))
@@ -240,11 +260,15 @@ func (a *API) QueryRange(r *http.Request) (interface{}, []error, *ApiError) {
 		return nil, nil, &ApiError{Typ: ErrorExec, Err: err}
 	}
 
-	level.Debug(a.logger).Log("query", queryString, "from", from, "to", to)
+	reqID, _ := requestid.FromContext(ctx)
+	level.Debug(a.logger).Log("query", queryString, "from", from, "to", to, "request_id", reqID)
 	sel, err := parser.ParseMetricSelector(queryString)
 	if err != nil {
 		return nil, nil, &ApiError{Typ: ErrorBadData, Err: err}
 	}
+	if err := a.validateMatchers(sel); err != nil {
+		return nil, nil, &ApiError{Typ: ErrorBadData, Err: err}
+	}
 
 	// Record query window
 	a.queryRangeHist.Observe(to.Sub(from).Seconds())
@@ -306,12 +330,10 @@ func (a *API) findProfile(ctx context.Context, t time.Time, sel []*labels.Matche
 	for set.Next() {
 		series := set.At()
 		i := series.Iterator()
-		for i.Next() {
-			ts, b := i.At()
-			if ts >= requestedTime {
-				// First profile whose timestamp is larger than or equal to the timestamp being searched for.
-				return profile.ParseData(b)
-			}
+		if i.Seek(requestedTime) {
+			// First profile whose timestamp is larger than or equal to the timestamp being searched for.
+			_, b := i.At()
+			return a.parseGate.parse(ctx, b)
 		}
 		err = i.Err()
 		if err != nil {
@@ -332,12 +354,18 @@ func (a *API) SingleProfileQuery(r *http.Request) (*profile.Profile, storage.War
 		r.URL.Query().Get("query"),
 		"",
 		"",
+		"",
 	)
 }
 
-func (a *API) profileByParameters(ctx context.Context, mode, time, query, from, to string) (*profile.Profile, storage.Warnings, *ApiError) {
+func (a *API) profileByParameters(ctx context.Context, mode, time, query, from, to, mergeOp string) (*profile.Profile, storage.Warnings, *ApiError) {
 	switch mode {
 	case "merge":
+		merge, err := mergeFuncByOp(mergeOp)
+		if err != nil {
+			return nil, nil, &ApiError{Typ: ErrorBadData, Err: err}
+		}
+
 		f, err := parseTime(from)
 		if err != nil {
 			return nil, nil, &ApiError{Typ: ErrorBadData, Err: err}
@@ -357,8 +385,11 @@ func (a *API) profileByParameters(ctx context.Context, mode, time, query, from,
 		if err != nil {
 			return nil, nil, &ApiError{Typ: ErrorBadData, Err: err}
 		}
+		if err := a.validateMatchers(sel); err != nil {
+			return nil, nil, &ApiError{Typ: ErrorBadData, Err: err}
+		}
 
-		return a.mergeProfiles(ctx, f, t, sel)
+		return a.mergeProfiles(ctx, f, t, sel, merge)
 	case "single":
 		t, err := parseTime(time)
 		if err != nil {
@@ -371,6 +402,9 @@ func (a *API) profileByParameters(ctx context.Context, mode, time, query, from,
 			err = fmt.Errorf("unable to parse query: %w", err)
 			return nil, nil, &ApiError{Typ: ErrorBadData, Err: err}
 		}
+		if err := a.validateMatchers(sel); err != nil {
+			return nil, nil, &ApiError{Typ: ErrorBadData, Err: err}
+		}
 
 		profile, err := a.findProfile(ctx, t, sel)
 		// TODO(bwplotka): Handle warnings.
@@ -397,6 +431,7 @@ func (a *API) DiffProfiles(r *http.Request) (*profile.Profile, storage.Warnings,
 		r.URL.Query().Get("query_a"),
 		r.URL.Query().Get("from_a"),
 		r.URL.Query().Get("to_a"),
+		r.URL.Query().Get("merge_op"),
 	)
 	if apiErr != nil {
 		return nil, nil, apiErr
@@ -408,6 +443,7 @@ func (a *API) DiffProfiles(r *http.Request) (*profile.Profile, storage.Warnings,
 		r.URL.Query().Get("query_b"),
 		r.URL.Query().Get("from_b"),
 		r.URL.Query().Get("to_b"),
+		r.URL.Query().Get("merge_op"),
 	)
 	if apiErr != nil {
 		return nil, nil, apiErr
@@ -470,6 +506,15 @@ func (a *API) Query(r *http.Request) (interface{}, []error, *ApiError) {
 		}
 	}
 
+	if r.URL.Query().Get("normalize") == "true" {
+		if r.URL.Query().Get("mode") == "diff" {
+			return nil, nil, &ApiError{Typ: ErrorBadData, Err: errors.New("normalize is not supported for diff queries")}
+		}
+		if err := normalizeByDuration(profile); err != nil {
+			warnings = append(warnings, err)
+		}
+	}
+
 	return &ProfileResponseRenderer{
 		logger:   a.logger,
 		profile:  profile,
@@ -478,6 +523,23 @@ func (a *API) Query(r *http.Request) (interface{}, []error, *ApiError) {
 	}, warnings, nil
 }
 
+// validateMatchers checks that the total number of matchers across all
+// matcher sets does not exceed the configured limit.
+func (a *API) validateMatchers(matcherSets ...[]*labels.Matcher) error {
+	if a.maxMatchers <= 0 {
+		return nil
+	}
+
+	n := 0
+	for _, ms := range matcherSets {
+		n += len(ms)
+	}
+	if n > a.maxMatchers {
+		return fmt.Errorf("query uses %d matchers, exceeding the limit of %d", n, a.maxMatchers)
+	}
+	return nil
+}
+
 func parseMetadataTimeRange(r *http.Request, defaultMetadataTimeRange time.Duration) (time.Time, time.Time, error) {
 	// If start and end time not specified as query parameter, we get the range from the beginning of time by default.
 	var defaultStartTime, defaultEndTime time.Time
@@ -557,6 +619,9 @@ func (a *API) Series(r *http.Request) (interface{}, []error, *ApiError) {
 		}
 		matcherSets = append(matcherSets, matchers)
 	}
+	if err := a.validateMatchers(matcherSets...); err != nil {
+		return nil, nil, &ApiError{Typ: ErrorBadData, Err: err}
+	}
 
 	q, err := a.db.Querier(ctx, timestamp.FromTime(start), timestamp.FromTime(end))
 	if err != nil {
@@ -605,6 +670,9 @@ func (a *API) LabelNames(r *http.Request) (interface{}, []error, *ApiError) {
 		}
 		matcherSets = append(matcherSets, matchers)
 	}
+	if err := a.validateMatchers(matcherSets...); err != nil {
+		return nil, nil, &ApiError{Typ: ErrorBadData, Err: err}
+	}
 
 	q, err := a.db.Querier(ctx, timestamp.FromTime(start), timestamp.FromTime(end))
 	if err != nil {
@@ -661,6 +729,9 @@ func (a *API) LabelValues(r *http.Request) (interface{}, []error, *ApiError) {
 		}
 		matcherSets = append(matcherSets, matchers)
 	}
+	if err := a.validateMatchers(matcherSets...); err != nil {
+		return nil, nil, &ApiError{Typ: ErrorBadData, Err: err}
+	}
 
 	q, err := a.db.Querier(ctx, timestamp.FromTime(start), timestamp.FromTime(end))
 	if err != nil {
@@ -696,6 +767,114 @@ func (a *API) LabelValues(r *http.Request) (interface{}, []error, *ApiError) {
 	return vals, warnings, nil
 }
 
+// BulkLabelValues returns the values of all labels given as name parameters
+// in a single response, mapping each label name to its sorted values. All
+// names share the same time range and match[] selectors.
+func (a *API) BulkLabelValues(r *http.Request) (interface{}, []error, *ApiError) {
+	ctx := r.Context()
+
+	start, end, err := parseMetadataTimeRange(r, defaultMetadataTimeRange)
+	if err != nil {
+		return nil, nil, &ApiError{Typ: ErrorBadData, Err: err}
+	}
+
+	names := r.Form["name"]
+	if len(names) == 0 {
+		return nil, nil, &ApiError{Typ: ErrorBadData, Err: errors.New("at least one label name must be provided")}
+	}
+	for _, name := range names {
+		if !model.LabelNameRE.MatchString(name) {
+			return nil, nil, &ApiError{Typ: ErrorBadData, Err: errors.Errorf("invalid label name: %q", name)}
+		}
+	}
+
+	var matcherSets [][]*labels.Matcher
+	for _, s := range r.Form["match[]"] {
+		matchers, err := parser.ParseMetricSelector(s)
+		if err != nil {
+			return nil, nil, &ApiError{Typ: ErrorBadData, Err: err}
+		}
+		matcherSets = append(matcherSets, matchers)
+	}
+	if err := a.validateMatchers(matcherSets...); err != nil {
+		return nil, nil, &ApiError{Typ: ErrorBadData, Err: err}
+	}
+
+	q, err := a.db.Querier(ctx, timestamp.FromTime(start), timestamp.FromTime(end))
+	if err != nil {
+		return nil, nil, &ApiError{Typ: ErrorExec, Err: err}
+	}
+
+	hints := &storage.SelectHints{
+		Start: timestamp.FromTime(start),
+		End:   timestamp.FromTime(end),
+		Func:  "series", // There is no series function, this token is used for lookups that don't need samples.
+	}
+
+	res := make(map[string][]string, len(names))
+	var warnings storage.Warnings
+	if len(matcherSets) > 0 {
+		// Get all series which match matchers once for all names.
+		var sets []storage.SeriesSet
+		for _, mset := range matcherSets {
+			sets = append(sets, q.Select(false, hints, mset...))
+		}
+		res, warnings, err = bulkLabelValuesByMatchers(sets, names)
+		if err != nil {
+			return nil, nil, &ApiError{Typ: ErrorExec, Err: err}
+		}
+	} else {
+		for _, name := range names {
+			vals, ws, err := q.LabelValues(name)
+			if err != nil {
+				return nil, nil, &ApiError{Typ: ErrorExec, Err: err}
+			}
+			warnings = append(warnings, ws...)
+			res[name] = append([]string{}, vals...)
+		}
+	}
+
+	return res, warnings, nil
+}
+
+// bulkLabelValuesByMatchers extracts the values of all given label names from
+// the series of the sets in a single pass. Series without a label don't
+// contribute an empty value.
+func bulkLabelValuesByMatchers(sets []storage.SeriesSet, names []string) (map[string][]string, storage.Warnings, error) {
+	valueSets := make(map[string]map[string]struct{}, len(names))
+	for _, name := range names {
+		valueSets[name] = map[string]struct{}{}
+	}
+
+	var warnings storage.Warnings
+	for _, set := range sets {
+		for set.Next() {
+			lset := set.At().Labels()
+			for name, values := range valueSets {
+				if v := lset.Get(name); v != "" {
+					values[v] = struct{}{}
+				}
+			}
+		}
+
+		warnings = append(warnings, set.Warnings()...)
+		if set.Err() != nil {
+			return nil, warnings, set.Err()
+		}
+	}
+
+	res := make(map[string][]string, len(valueSets))
+	for name, values := range valueSets {
+		vals := make([]string, 0, len(values))
+		for v := range values {
+			vals = append(vals, v)
+		}
+		sort.Strings(vals)
+		res[name] = vals
+	}
+	return res, warnings, nil
+}
+
 // LabelValuesByMatchers uses matchers to filter out matching series, then label values are extracted.
 func labelValuesByMatchers(sets []storage.SeriesSet, name string) ([]string, storage.Warnings, error) {
 	set := storage.NewMergeSeriesSet(sets, storage.ChainedSeriesMerge)
@@ -719,21 +898,25 @@ func labelValuesByMatchers(sets []storage.SeriesSet, name string) ([]string, sto
 	return labelValues, warnings, nil
 }
 
-// LabelNamesByMatchers uses matchers to filter out matching series, then label names are extracted.
+// labelNamesByMatchers returns the union of the label names of all series in
+// any of the sets, the same semantics as Prometheus. The sets are consumed one
+// after the other rather than merged, as merging requires sorted sets.
 func labelNamesByMatchers(sets []storage.SeriesSet) ([]string, storage.Warnings, error) {
-	set := storage.NewMergeSeriesSet(sets, storage.ChainedSeriesMerge)
 	labelNamesSet := make(map[string]struct{})
-	for set.Next() {
-		series := set.At()
-		labelNames := series.Labels()
-		for _, labelName := range labelNames {
-			labelNamesSet[labelName.Name] = struct{}{}
+	var warnings storage.Warnings
+	for _, set := range sets {
+		for set.Next() {
+			series := set.At()
+			labelNames := series.Labels()
+			for _, labelName := range labelNames {
+				labelNamesSet[labelName.Name] = struct{}{}
+			}
 		}
-	}
 
-	warnings := set.Warnings()
-	if set.Err() != nil {
-		return nil, warnings, set.Err()
+		warnings = append(warnings, set.Warnings()...)
+		if set.Err() != nil {
+			return nil, warnings, set.Err()
+		}
 	}
 	// Convert the map to an array.
 	labelNames := make([]string, 0, len(labelNamesSet))
@@ -778,6 +961,7 @@ type Target struct {
 	ScrapeURL  string `json:"scrapeUrl"`
 	GlobalURL  string `json:"globalUrl"`
 
+	ScrapeInterval     string              `json:"scrapeInterval"`
 	LastError          string              `json:"lastError"`
 	LastScrape         time.Time           `json:"lastScrape"`
 	LastScrapeDuration float64             `json:"lastScrapeDuration"`
@@ -848,6 +1032,7 @@ func (a *API) Targets(r *http.Request) (interface{}, []error, *ApiError) {
 						}
 						return lastErrStr
 					}(),
+					ScrapeInterval:     model.Duration(target.ScrapeInterval()).String(),
 					LastScrape:         target.LastScrape(),
 					LastScrapeDuration: target.LastScrapeDuration().Seconds(),
 					Health:             target.Health(),
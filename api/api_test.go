@@ -28,6 +28,7 @@ import (
 
 	"github.com/go-kit/kit/log"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
 	"github.com/prometheus/common/route"
 	"github.com/prometheus/prometheus/pkg/labels"
 	"github.com/prometheus/prometheus/pkg/timestamp"
@@ -40,6 +41,7 @@ import (
 	"github.com/conprof/conprof/pkg/store"
 	"github.com/conprof/conprof/pkg/store/storepb"
 	"github.com/conprof/conprof/pkg/testutil"
+	"github.com/conprof/conprof/scrape"
 	"github.com/conprof/db/tsdb/chunkenc"
 )
 
@@ -370,6 +372,10 @@ func TestAPILabelNames(t *testing.T) {
 			labels.Label{Name: "foo", Value: "boo"},
 			labels.Label{Name: "baz", Value: "faz"},
 		},
+		{
+			labels.Label{Name: "__name__", Value: "heap"},
+			labels.Label{Name: "qux", Value: "quux"},
+		},
 	}
 
 	db, err := testutil.NewTSDB()
@@ -398,13 +404,24 @@ func TestAPILabelNames(t *testing.T) {
 		{
 			endpoint: api.LabelNames,
 			query:    url.Values{},
-			response: []string{"__name__", "baz", "foo"},
+			response: []string{"__name__", "baz", "foo", "qux"},
 		},
 		{
 			endpoint: api.LabelNames,
 			query:    url.Values{"match[]": []string{"allocs"}},
 			response: []string{"__name__", "foo"},
 		},
+		// Union of the label names of series matched by any of the matchers.
+		{
+			endpoint: api.LabelNames,
+			query:    url.Values{"match[]": []string{"allocs", "heap"}},
+			response: []string{"__name__", "foo", "qux"},
+		},
+		{
+			endpoint: api.LabelNames,
+			query:    url.Values{"match[]": []string{"allocs", `{foo=~"b.*"}`}},
+			response: []string{"__name__", "baz", "foo"},
+		},
 		// Invalid format.
 		{
 			endpoint: api.LabelNames,
@@ -498,6 +515,89 @@ func TestAPILabelValues(t *testing.T) {
 	}
 }
 
+func TestAPIBulkLabelValues(t *testing.T) {
+	lbls := []labels.Labels{
+		{
+			labels.Label{Name: "__name__", Value: "allocs"},
+			labels.Label{Name: "foo", Value: "bar"},
+		},
+		{
+			labels.Label{Name: "__name__", Value: "goroutine"},
+			labels.Label{Name: "foo", Value: "boo"},
+		},
+		{
+			labels.Label{Name: "__name__", Value: "heap"},
+		},
+	}
+
+	db, err := testutil.NewTSDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		db.Close()
+	}()
+
+	app := db.Appender(context.Background())
+	for _, lbl := range lbls {
+		_, err := app.Add(lbl, timestamp.FromTime(time.Now()), []byte{0})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := app.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	api := New(log.NewNopLogger(), prometheus.NewRegistry(), WithDB(db))
+	var tests = []endpointTestCase{
+		{
+			endpoint: api.BulkLabelValues,
+			query:    url.Values{"name": []string{"__name__", "foo"}},
+			response: map[string][]string{
+				"__name__": {"allocs", "goroutine", "heap"},
+				"foo":      {"bar", "boo"},
+			},
+		},
+		{
+			endpoint: api.BulkLabelValues,
+			query: url.Values{
+				"name":    []string{"__name__", "foo"},
+				"match[]": []string{`{foo="bar"}`, "heap"},
+			},
+			response: map[string][]string{
+				"__name__": {"allocs", "heap"},
+				"foo":      {"bar"},
+			},
+		},
+		{
+			endpoint: api.BulkLabelValues,
+			query:    url.Values{"name": []string{"missing"}},
+			response: map[string][]string{
+				"missing": {},
+			},
+		},
+		// No names.
+		{
+			endpoint: api.BulkLabelValues,
+			query:    url.Values{},
+			errType:  ErrorBadData,
+		},
+		// Empty name.
+		{
+			endpoint: api.BulkLabelValues,
+			query:    url.Values{"name": []string{"__name__", ""}},
+			errType:  ErrorBadData,
+		},
+	}
+
+	for i, test := range tests {
+		if ok := testEndpoint(t, test, fmt.Sprintf("#%d %s", i, test.query.Encode())); !ok {
+			return
+		}
+	}
+}
+
 func TestAPISeries(t *testing.T) {
 	lbls := []labels.Labels{
 		{
@@ -620,3 +720,115 @@ func createGRPCAPI(t *testing.T, read storepb.ReadableProfileStoreServer, write
 		WithQueryTimeout(200*time.Millisecond),
 	), lis
 }
+
+func TestAPIMaxMatchers(t *testing.T) {
+	db, err := testutil.NewTSDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		db.Close()
+	}()
+
+	app := db.Appender(context.Background())
+	_, err = app.Add(labels.FromStrings("__name__", "allocs", "foo", "bar"), timestamp.FromTime(time.Now()), []byte{0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := app.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	api := New(log.NewNopLogger(), prometheus.NewRegistry(), WithDB(db), WithMaxMatchers(2))
+	var tests = []endpointTestCase{
+		{
+			endpoint: api.Series,
+			query:    url.Values{"match[]": []string{`allocs{foo="bar"}`}},
+			response: []labels.Labels{
+				labels.FromStrings("__name__", "allocs", "foo", "bar"),
+			},
+		},
+		// Too many matchers within one selector.
+		{
+			endpoint: api.Series,
+			query:    url.Values{"match[]": []string{`allocs{foo="bar",baz!="qux"}`}},
+			errType:  ErrorBadData,
+		},
+		// Too many matchers across multiple match[] entries.
+		{
+			endpoint: api.LabelValues,
+			params:   map[string]string{"name": "foo"},
+			query:    url.Values{"match[]": []string{`allocs{foo="bar"}`, `heap`}},
+			errType:  ErrorBadData,
+		},
+		{
+			endpoint: api.LabelNames,
+			query:    url.Values{"match[]": []string{`allocs`, `heap`, `goroutine`}},
+			errType:  ErrorBadData,
+		},
+		{
+			endpoint: api.QueryRange,
+			query: url.Values{
+				"query": []string{`allocs{foo="bar",baz!="qux"}`},
+				"from":  []string{"0"},
+				"to":    []string{"10"},
+			},
+			errType: ErrorBadData,
+		},
+		{
+			endpoint: api.Query,
+			query: url.Values{
+				"mode":  []string{"single"},
+				"query": []string{`allocs{foo="bar",baz!="qux"}`},
+				"time":  []string{"3"},
+			},
+			errType: ErrorBadData,
+		},
+	}
+
+	for i, test := range tests {
+		if ok := testEndpoint(t, test, fmt.Sprintf("#%d %s", i, test.query.Encode())); !ok {
+			return
+		}
+	}
+}
+
+type fakeTargetRetriever struct {
+	active map[string][]*scrape.Target
+}
+
+func (r fakeTargetRetriever) TargetsActive() map[string][]*scrape.Target {
+	return r.active
+}
+
+func (r fakeTargetRetriever) TargetsDropped() map[string][]*scrape.Target {
+	return map[string][]*scrape.Target{}
+}
+
+func TestAPITargets(t *testing.T) {
+	target := scrape.NewTarget(labels.FromStrings(
+		model.SchemeLabel, "http",
+		model.AddressLabel, "localhost:8080",
+		scrape.ProfilePath, "/debug/pprof/heap",
+		"job", "app",
+	), nil, nil)
+
+	api := New(log.NewNopLogger(), prometheus.NewRegistry(), WithTargets(func(_ context.Context) TargetRetriever {
+		return fakeTargetRetriever{active: map[string][]*scrape.Target{"app": {target}}}
+	}))
+
+	req, err := http.NewRequest(http.MethodGet, "http://example.com/targets", nil)
+	require.NoError(t, err)
+
+	res, _, apiErr := api.Targets(req)
+	require.Nil(t, apiErr)
+
+	targets := res.(*TargetDiscovery).ActiveTargets
+	require.Len(t, targets, 1)
+	require.Equal(t, "app", targets[0].ScrapePool)
+	require.Equal(t, "http://localhost:8080/debug/pprof/heap", targets[0].ScrapeURL)
+	require.Equal(t, map[string]string{"job": "app"}, targets[0].Labels)
+	require.Equal(t, scrape.HealthUnknown, targets[0].Health)
+	require.Equal(t, "0s", targets[0].ScrapeInterval)
+	require.Equal(t, "", targets[0].LastError)
+}
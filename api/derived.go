@@ -0,0 +1,139 @@
+// Copyright 2020 The conprof Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/google/pprof/profile"
+)
+
+const (
+	// derivedSampleIndexPrefix selects a derived sample type, for example
+	// sample_index=derived:objects.
+	derivedSampleIndexPrefix = "derived:"
+
+	DerivedObjects = "objects"
+	DerivedSpace   = "space"
+
+	// estimatedSuffix marks sample types that were not recorded but derived.
+	estimatedSuffix = "_estimated"
+)
+
+// isEstimatedSampleType returns whether the sample type was derived by
+// deriveSampleType rather than recorded.
+func isEstimatedSampleType(t string) bool {
+	return strings.HasSuffix(t, estimatedSuffix)
+}
+
+// applySampleIndex returns the profile and sample index to render. For
+// derived sample indexes a copy of the profile with the estimated sample type
+// added is returned, other sample indexes are passed through unchanged.
+func applySampleIndex(p *profile.Profile, sampleIndex string) (*profile.Profile, string, error) {
+	if !strings.HasPrefix(sampleIndex, derivedSampleIndexPrefix) {
+		return p, sampleIndex, nil
+	}
+
+	p = p.Copy()
+	name, err := deriveSampleType(p, strings.TrimPrefix(sampleIndex, derivedSampleIndexPrefix))
+	if err != nil {
+		return nil, "", &ApiError{Typ: ErrorBadData, Err: err}
+	}
+	return p, name, nil
+}
+
+// deriveSampleType adds an estimated objects or space sample type to the
+// profile and returns its name. Estimates use the average object size per
+// leaf location, so both the objects and space sample types of the same kind
+// (alloc or inuse) need to be recorded.
+func deriveSampleType(p *profile.Profile, target string) (string, error) {
+	if target != DerivedObjects && target != DerivedSpace {
+		return "", fmt.Errorf("unknown derived sample type %q, valid options are %q and %q", target, DerivedObjects, DerivedSpace)
+	}
+
+	kind, objectsIdx, spaceIdx, ok := objectsAndSpaceIndex(p)
+	if !ok {
+		return "", fmt.Errorf("deriving %s requires both objects and space sample types", target)
+	}
+
+	type total struct{ objects, space int64 }
+	totals := map[uint64]*total{}
+	for _, s := range p.Sample {
+		k := leafLocationID(s)
+		t, ok := totals[k]
+		if !ok {
+			t = &total{}
+			totals[k] = t
+		}
+		t.objects += s.Value[objectsIdx]
+		t.space += s.Value[spaceIdx]
+	}
+
+	for _, s := range p.Sample {
+		t := totals[leafLocationID(s)]
+		var v int64
+		if t.objects != 0 && t.space != 0 {
+			avgSize := float64(t.space) / float64(t.objects)
+			switch target {
+			case DerivedObjects:
+				v = int64(math.Round(float64(s.Value[spaceIdx]) / avgSize))
+			case DerivedSpace:
+				v = int64(math.Round(float64(s.Value[objectsIdx]) * avgSize))
+			}
+		}
+		s.Value = append(s.Value, v)
+	}
+
+	unit := p.SampleType[objectsIdx].Unit
+	if target == DerivedSpace {
+		unit = p.SampleType[spaceIdx].Unit
+	}
+	name := kind + "_" + target + estimatedSuffix
+	p.SampleType = append(p.SampleType, &profile.ValueType{Type: name, Unit: unit})
+	return name, nil
+}
+
+// objectsAndSpaceIndex finds a pair of objects and space sample types of the
+// same kind, preferring the kind of the default sample type.
+func objectsAndSpaceIndex(p *profile.Profile) (kind string, objectsIdx, spaceIdx int, ok bool) {
+	kinds := []string{"alloc", "inuse"}
+	if i := strings.Index(p.DefaultSampleType, "_"); i > 0 {
+		kinds = append([]string{p.DefaultSampleType[:i]}, kinds...)
+	}
+
+	for _, kind := range kinds {
+		objectsIdx, spaceIdx = -1, -1
+		for i, st := range p.SampleType {
+			switch st.Type {
+			case kind + "_objects":
+				objectsIdx = i
+			case kind + "_space":
+				spaceIdx = i
+			}
+		}
+		if objectsIdx >= 0 && spaceIdx >= 0 {
+			return kind, objectsIdx, spaceIdx, true
+		}
+	}
+	return "", 0, 0, false
+}
+
+func leafLocationID(s *profile.Sample) uint64 {
+	if len(s.Location) == 0 {
+		return 0
+	}
+	return s.Location[0].ID
+}
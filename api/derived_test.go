@@ -0,0 +1,120 @@
+// Copyright 2020 The conprof Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/google/pprof/profile"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeriveSampleType(t *testing.T) {
+	b, err := ioutil.ReadFile("testdata/alloc_objects.pb.gz")
+	require.NoError(t, err)
+
+	for _, c := range []struct {
+		target   string
+		name     string
+		recorded string
+	}{
+		{target: DerivedObjects, name: "alloc_objects_estimated", recorded: "alloc_objects"},
+		{target: DerivedSpace, name: "alloc_space_estimated", recorded: "alloc_space"},
+	} {
+		t.Run(c.target, func(t *testing.T) {
+			p, err := profile.ParseData(b)
+			require.NoError(t, err)
+
+			derived, name, err := applySampleIndex(p, derivedSampleIndexPrefix+c.target)
+			require.NoError(t, err)
+			require.Equal(t, c.name, name)
+			// The original profile is left untouched.
+			require.Len(t, p.SampleType, 4)
+
+			estimatedIdx, err := derived.SampleIndexByName(name)
+			require.NoError(t, err)
+			recordedIdx, err := derived.SampleIndexByName(c.recorded)
+			require.NoError(t, err)
+
+			var estimated, recorded int64
+			for _, s := range derived.Sample {
+				require.Len(t, s.Value, len(derived.SampleType))
+				estimated += s.Value[estimatedIdx]
+				recorded += s.Value[recordedIdx]
+			}
+			require.NotZero(t, estimated)
+			// Per location averages preserve totals up to rounding.
+			require.InEpsilon(t, recorded, estimated, 0.01)
+			require.NoError(t, derived.CheckValid())
+		})
+	}
+}
+
+func TestDeriveSampleTypeMissingBaseType(t *testing.T) {
+	b, err := ioutil.ReadFile("testdata/alloc_objects.pb.gz")
+	require.NoError(t, err)
+
+	p, err := profile.ParseData(b)
+	require.NoError(t, err)
+	// Only keep the space sample types.
+	p.SampleType = []*profile.ValueType{p.SampleType[1], p.SampleType[3]}
+	for _, s := range p.Sample {
+		s.Value = []int64{s.Value[1], s.Value[3]}
+	}
+
+	_, _, err = applySampleIndex(p, "derived:objects")
+	require.Error(t, err)
+	require.Equal(t, ErrorBadData, err.(*ApiError).Typ)
+
+	_, _, err = applySampleIndex(p, "derived:bytes")
+	require.Error(t, err)
+}
+
+func TestRenderMetaDerivedSampleType(t *testing.T) {
+	b, err := ioutil.ReadFile("testdata/alloc_objects.pb.gz")
+	require.NoError(t, err)
+
+	p, err := profile.ParseData(b)
+	require.NoError(t, err)
+
+	v := url.Values{}
+	v.Set("report", "meta")
+	v.Set("sample_index", "derived:objects")
+	u := &url.URL{
+		Scheme:   "http",
+		Host:     "example.com",
+		RawQuery: v.Encode(),
+	}
+	req := httptest.NewRequest("GET", u.String(), nil)
+
+	w := httptest.NewRecorder()
+	require.NoError(t, NewProfileResponseRenderer(log.NewNopLogger(), p, nil, req).Render(w))
+
+	var res struct {
+		Data MetaReport `json:"data"`
+	}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+	require.Equal(t, []ValueType{
+		{Type: "alloc_objects"},
+		{Type: "alloc_space"},
+		{Type: "inuse_objects"},
+		{Type: "inuse_space"},
+		{Type: "alloc_objects_estimated", Estimated: true},
+	}, res.Data.SampleTypes)
+}
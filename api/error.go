@@ -25,8 +25,9 @@ import (
 	"github.com/julienschmidt/httprouter"
 	"github.com/prometheus/common/route"
 	extpromhttp "github.com/thanos-io/thanos/pkg/extprom/http"
-	"github.com/thanos-io/thanos/pkg/server/http/middleware"
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+
+	"github.com/conprof/conprof/pkg/requestid"
 )
 
 type Status string
@@ -79,14 +80,23 @@ func Instr(
 ) func(name string, f ApiFunc) httprouter.Handle {
 	instr := func(name string, f ApiFunc) httprouter.Handle {
 		hf := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			reqID, _ := requestid.FromContext(r.Context())
 			data, warnings, apiErr := f(r)
+			if apiErr != nil && (apiErr.Typ == ErrorInternal || apiErr.Typ == ErrorExec) {
+				level.Error(logger).Log("msg", "failed to execute request", "handler", name, "request_id", reqID, "err", apiErr)
+			}
 			ren := chooseRenderer(data, warnings, apiErr)
 			err := ren.Render(w)
 			if err != nil {
-				// Attempt to show the user the error.
-				ren = chooseRenderer(nil, nil, &ApiError{Typ: ErrorInternal, Err: err})
+				// Attempt to show the user the error. Renderers may return
+				// an *ApiError to signal the type of error.
+				apiErr, ok := err.(*ApiError)
+				if !ok {
+					apiErr = &ApiError{Typ: ErrorInternal, Err: err}
+				}
+				ren = chooseRenderer(nil, nil, apiErr)
 				renErr := ren.Render(w)
-				level.Error(logger).Log("msg", "failed to render error", "err", err, "render_error", renErr)
+				level.Error(logger).Log("msg", "failed to render error", "handler", name, "request_id", reqID, "err", err, "render_error", renErr)
 			}
 		})
 		return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
@@ -96,7 +106,7 @@ func Instr(
 			for _, p := range params {
 				ctx = route.WithParam(ctx, p.Key, p.Value)
 			}
-			otelhttp.NewHandler(ins.NewHandler(name, gziphandler.GzipHandler(middleware.RequestID(hf))), name).ServeHTTP(w, r.WithContext(ctx))
+			otelhttp.NewHandler(ins.NewHandler(name, gziphandler.GzipHandler(requestid.Handler(hf))), name).ServeHTTP(w, r.WithContext(ctx))
 		}
 	}
 	return instr
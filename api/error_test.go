@@ -0,0 +1,53 @@
+// Copyright 2020 The conprof Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+	extpromhttp "github.com/thanos-io/thanos/pkg/extprom/http"
+
+	"github.com/conprof/conprof/pkg/requestid"
+)
+
+func TestInstrRequestID(t *testing.T) {
+	instr := Instr(log.NewNopLogger(), extpromhttp.NewInstrumentationMiddleware(prometheus.NewRegistry()))
+
+	var seen string
+	h := instr("test", func(r *http.Request) (interface{}, []error, *ApiError) {
+		seen, _ = requestid.FromContext(r.Context())
+		return "ok", nil, nil
+	})
+
+	// Incoming request ID is echoed and propagated.
+	req := httptest.NewRequest("GET", "http://example.com", nil)
+	req.Header.Set(requestid.HeaderName, "my-request-id")
+	w := httptest.NewRecorder()
+	h(w, req, nil)
+	require.Equal(t, "my-request-id", w.Result().Header.Get(requestid.HeaderName))
+	require.Equal(t, "my-request-id", seen)
+
+	// A request ID is generated when absent.
+	req = httptest.NewRequest("GET", "http://example.com", nil)
+	w = httptest.NewRecorder()
+	h(w, req, nil)
+	generated := w.Result().Header.Get(requestid.HeaderName)
+	require.NotEmpty(t, generated)
+	require.Equal(t, generated, seen)
+}
@@ -30,11 +30,47 @@ type TreeNode struct {
 	CumFormat string      `json:"l"`
 	Percent   string      `json:"p"`
 	Children  []*TreeNode `json:"c"`
+
+	// File and Line point to the node's function in source, when the
+	// profile carries that information.
+	File string `json:"file,omitempty"`
+	Line int64  `json:"line,omitempty"`
+}
+
+type sourceLocation struct {
+	file string
+	line int64
+}
+
+// functionSourceLocations returns the source location of each function in
+// the profile by name. The function's start line is used when recorded,
+// otherwise the lowest line sampled within the function.
+func functionSourceLocations(p *profile.Profile) map[string]sourceLocation {
+	locs := map[string]sourceLocation{}
+	for _, l := range p.Location {
+		for _, line := range l.Line {
+			fn := line.Function
+			if fn == nil || fn.Name == "" || fn.Filename == "" {
+				continue
+			}
+			loc, ok := locs[fn.Name]
+			if !ok {
+				loc = sourceLocation{file: fn.Filename, line: fn.StartLine}
+			}
+			if fn.StartLine == 0 && line.Line > 0 && (loc.line == 0 || line.Line < loc.line) {
+				loc.line = line.Line
+			}
+			locs[fn.Name] = loc
+		}
+	}
+	return locs
 }
 
 // Largely copied from https://github.com/google/pprof/blob/master/internal/driver/flamegraph.go
 func generateFlamegraphReport(p *profile.Profile, sampleIndex string) (*TreeNode, error) {
 	numLabelUnits, _ := p.NumLabelUnits()
+	// Aggregating drops file and line information, so look it up first.
+	sourceLocs := functionSourceLocations(p)
 	err := p.Aggregate(true, true, false, false, false)
 	if err != nil {
 		return nil, err
@@ -77,6 +113,10 @@ func generateFlamegraphReport(p *profile.Profile, sampleIndex string) (*TreeNode
 			CumFormat: config.FormatValue(v),
 			Percent:   strings.TrimSpace(measurement.Percentage(v, config.Total)),
 		}
+		if loc, ok := sourceLocs[n.Info.Name]; ok {
+			node.File = loc.file
+			node.Line = loc.line
+		}
 		nodes = append(nodes, node)
 		if len(n.In) == 0 {
 			nodes[nroots], nodes[len(nodes)-1] = nodes[len(nodes)-1], nodes[nroots]
@@ -51,3 +51,43 @@ func TestDiffFlamegraph(t *testing.T) {
 	// Marshals successfully to json.
 	require.NoError(t, err)
 }
+
+func TestFlamegraphSourceLocations(t *testing.T) {
+	f, err := os.Open("testdata/alloc_objects.pb.gz")
+	require.NoError(t, err)
+	p, err := profile.Parse(f)
+	require.NoError(t, err)
+
+	root, err := generateFlamegraphReport(p, "")
+	require.NoError(t, err)
+
+	// The synthetic root has no source location.
+	require.Equal(t, "", root.File)
+	require.Equal(t, int64(0), root.Line)
+
+	var found bool
+	var walk func(n *TreeNode)
+	walk = func(n *TreeNode) {
+		if n == nil {
+			return
+		}
+		if n.FullName == "k8s.io/apimachinery/pkg/util/net.CloneHeader" {
+			found = true
+			require.Equal(t, "/home/runner/go/pkg/mod/k8s.io/apimachinery@v0.19.2/pkg/util/net/http.go", n.File)
+			require.True(t, n.Line > 0)
+		}
+		for _, c := range n.Children {
+			walk(c)
+		}
+	}
+	for _, c := range root.Children {
+		require.NotEqual(t, "", c.File)
+		require.True(t, c.Line > 0)
+		walk(c)
+	}
+	require.True(t, found)
+
+	b, err := json.Marshal(root)
+	require.NoError(t, err)
+	require.NotContains(t, string(b[:bytes.IndexByte(b, '[')]), `"file"`)
+}
@@ -4,6 +4,9 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/conprof/db/storage"
@@ -29,19 +32,31 @@ func (e *MergeTimeoutError) Error() string {
 	return fmt.Sprintf("merge timeout exceeded, used partial merge of %d samples", e.mergedSamplesCount)
 }
 
+// batchIterator iterates over the samples of a series set between mint and
+// maxt in batches of at most maxBatchSize bytes. Chunks may span a wider range
+// than queried, samples outside of it are skipped by seeking so they are never
+// decoded.
+// batchIterator iterates over the samples of a series set between mint and
+// maxt in batches of at most maxBatchSize bytes. Chunks may span a wider range
+// than queried, so samples before mint are skipped by seeking and iteration of
+// a series stops after maxt, without decoding any of the skipped profiles.
 type batchIterator struct {
 	set          storage.SeriesSet
 	curIterator  chunkenc.Iterator
+	seeked       bool
+	mint, maxt   int64
 	maxBatchSize int64
 	err          error
 
 	batch [][]byte
 }
 
-func newBatchIterator(set storage.SeriesSet, maxBatchSize int64) *batchIterator {
+func newBatchIterator(set storage.SeriesSet, mint, maxt, maxBatchSize int64) *batchIterator {
 	return &batchIterator{
 		set:          set,
 		curIterator:  nil,
+		mint:         mint,
+		maxt:         maxt,
 		maxBatchSize: maxBatchSize,
 		batch:        [][]byte{},
 		err:          nil,
@@ -52,35 +67,42 @@ func (i *batchIterator) Next() bool {
 	batchSize := int64(0)
 	i.batch = i.batch[:0]
 
-	// Finish previsous iterator if unfinished.
-	if i.curIterator != nil {
-		for i.curIterator.Next() {
-			_, b := i.curIterator.At()
-			if err := i.curIterator.Err(); err != nil {
-				i.err = i.curIterator.Err()
-				return false
-			}
-			i.batch = append(i.batch, b)
-			batchSize += int64(len(b))
-			if batchSize >= i.maxBatchSize {
-				return true
+	for {
+		// Continue with the previous iterator if unfinished.
+		if i.curIterator == nil {
+			if !i.set.Next() {
+				break
 			}
+			i.curIterator = i.set.At().Iterator()
+			i.seeked = false
 		}
-	}
-	for i.set.Next() {
-		series := i.set.At()
-		i.curIterator = series.Iterator()
-		for i.curIterator.Next() {
-			_, b := i.curIterator.At()
-			if err := i.curIterator.Err(); err != nil {
-				i.err = i.curIterator.Err()
-				return false
-			}
-			i.batch = append(i.batch, b)
-			batchSize += int64(len(b))
-			if batchSize >= i.maxBatchSize {
-				return true
-			}
+
+		var ok bool
+		if !i.seeked {
+			ok = i.curIterator.Seek(i.mint)
+			i.seeked = true
+		} else {
+			ok = i.curIterator.Next()
+		}
+		if err := i.curIterator.Err(); err != nil {
+			i.err = err
+			return false
+		}
+		if !ok {
+			i.curIterator = nil
+			continue
+		}
+
+		t, b := i.curIterator.At()
+		if t > i.maxt {
+			// Samples are ordered by time, so the rest of the series is out of range.
+			i.curIterator = nil
+			continue
+		}
+		i.batch = append(i.batch, b)
+		batchSize += int64(len(b))
+		if batchSize >= i.maxBatchSize {
+			return true
 		}
 	}
 	if err := i.set.Err(); err != nil {
@@ -100,17 +122,112 @@ func (i *batchIterator) Err() error {
 	return i.err
 }
 
-func (a *API) mergeProfiles(ctx context.Context, from, to time.Time, sel []*labels.Matcher) (*profile.Profile, storage.Warnings, *ApiError) {
-	q, err := a.db.Querier(ctx, timestamp.FromTime(from), timestamp.FromTime(to))
+const (
+	MergeOpSum = "sum"
+	MergeOpMax = "max"
+)
+
+type mergeFunc func([]*profile.Profile) (*profile.Profile, error)
+
+// mergeFuncByOp returns the function used to aggregate profiles for the
+// given merge operation. An empty operation defaults to summing profiles.
+func mergeFuncByOp(op string) (mergeFunc, error) {
+	switch op {
+	case "", MergeOpSum:
+		return profile.Merge, nil
+	case MergeOpMax:
+		return mergeMax, nil
+	default:
+		return nil, fmt.Errorf("unknown merge operation %q, valid options are %q and %q", op, MergeOpSum, MergeOpMax)
+	}
+}
+
+// mergeSourceLabel is temporarily attached to samples while merging with
+// mergeMax, so samples of different profiles are not summed up by
+// profile.Merge.
+const mergeSourceLabel = "__conprof_merge_source"
+
+// mergeMax merges profiles, using the maximum value of each sample across
+// all profiles instead of the sum. This is appropriate for gauge sample
+// types such as inuse_space, where summing overstates the actual value.
+func mergeMax(profiles []*profile.Profile) (*profile.Profile, error) {
+	for i, p := range profiles {
+		src := strconv.Itoa(i)
+		for _, s := range p.Sample {
+			if s.Label == nil {
+				s.Label = map[string][]string{}
+			}
+			s.Label[mergeSourceLabel] = []string{src}
+		}
+	}
+	merged, err := profile.Merge(profiles)
+	for _, p := range profiles {
+		for _, s := range p.Sample {
+			delete(s.Label, mergeSourceLabel)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	samples := make(map[string]*profile.Sample, len(merged.Sample))
+	res := merged.Sample[:0]
+	for _, s := range merged.Sample {
+		delete(s.Label, mergeSourceLabel)
+		k := sampleKey(s)
+		if existing, ok := samples[k]; ok {
+			for i, v := range s.Value {
+				if v > existing.Value[i] {
+					existing.Value[i] = v
+				}
+			}
+			continue
+		}
+		samples[k] = s
+		res = append(res, s)
+	}
+	merged.Sample = res
+
+	// Remove locations and functions no longer referenced.
+	return merged.Compact(), nil
+}
+
+// sampleKey identifies a sample by its stack and labels. Location IDs are
+// only comparable within the same profile.
+func sampleKey(s *profile.Sample) string {
+	ids := make([]string, 0, len(s.Location))
+	for _, l := range s.Location {
+		ids = append(ids, strconv.FormatUint(l.ID, 16))
+	}
+
+	lbls := make([]string, 0, len(s.Label)+len(s.NumLabel))
+	for k, v := range s.Label {
+		lbls = append(lbls, fmt.Sprintf("%q%q", k, v))
+	}
+	for k, v := range s.NumLabel {
+		lbls = append(lbls, fmt.Sprintf("%q%x%x", k, v, s.NumUnit[k]))
+	}
+	sort.Strings(lbls)
+
+	return strings.Join(ids, "|") + ";" + strings.Join(lbls, "")
+}
+
+func (a *API) mergeProfiles(ctx context.Context, from, to time.Time, sel []*labels.Matcher, merge mergeFunc) (*profile.Profile, storage.Warnings, *ApiError) {
+	mint, maxt := timestamp.FromTime(from), timestamp.FromTime(to)
+	q, err := a.db.Querier(ctx, mint, maxt)
 	if err != nil {
 		return nil, nil, &ApiError{Typ: ErrorExec, Err: err}
 	}
 
 	set := q.Select(false, nil, sel...)
-	mergedProfile, count, err := mergeSeriesSet(ctx, set, a.maxMergeBatchSize)
+	mergedProfile, count, err := mergeSeriesSet(ctx, set, mint, maxt, a.maxMergeBatchSize, merge, a.parseGate)
 	if err != nil && err != context.DeadlineExceeded {
 		return nil, nil, &ApiError{Typ: ErrorInternal, Err: err}
 	}
+	if err != nil && mergedProfile == nil {
+		// Timed out before even the first profile was parsed.
+		return nil, nil, &ApiError{Typ: ErrorTimeout, Err: err}
+	}
 	var warnings storage.Warnings = nil
 	if err != nil && err == context.DeadlineExceeded {
 		warnings = append(warnings, NewMergeTimeoutError(count))
@@ -120,11 +237,19 @@ func (a *API) mergeProfiles(ctx context.Context, from, to time.Time, sel []*labe
 	return mergedProfile, warnings, nil
 }
 
-func mergeSeriesSet(ctx context.Context, set storage.SeriesSet, maxMergeBatchSize int64) (*profile.Profile, int, error) {
-	bi := newBatchIterator(set, maxMergeBatchSize)
+func mergeSeriesSet(ctx context.Context, set storage.SeriesSet, mint, maxt, maxMergeBatchSize int64, merge mergeFunc, gate parseGate) (*profile.Profile, int, error) {
+	bi := newBatchIterator(set, mint, maxt, maxMergeBatchSize)
 	profiles := []*profile.Profile{}
 	var acc *profile.Profile = nil
 	count := 0
+	// A merged profile's duration is the sum of all durations, if any of the
+	// profiles has an unknown duration so does the merged profile.
+	unknownDuration := false
+	defer func() {
+		if acc != nil && unknownDuration {
+			acc.DurationNanos = 0
+		}
+	}()
 	for bi.Next() {
 		profiles = profiles[:0]
 		batch := bi.Batch()
@@ -132,10 +257,13 @@ func mergeSeriesSet(ctx context.Context, set storage.SeriesSet, maxMergeBatchSiz
 		if acc == nil && len(batch) > 0 {
 			firstProfileBytes := batch[0]
 			var err error
-			acc, err = profile.ParseData(firstProfileBytes)
+			acc, err = gate.parse(ctx, firstProfileBytes)
 			if err != nil {
 				return nil, 0, err
 			}
+			if !hasKnownDuration(acc) {
+				unknownDuration = true
+			}
 
 			// Process all but the first profile as we have already parsed it
 			// to be the base profile.
@@ -149,10 +277,13 @@ func mergeSeriesSet(ctx context.Context, set storage.SeriesSet, maxMergeBatchSiz
 			default:
 			}
 
-			p, err := profile.ParseData(b)
+			p, err := gate.parse(ctx, b)
 			if err != nil {
 				return acc, count, err
 			}
+			if !hasKnownDuration(p) {
+				unknownDuration = true
+			}
 			profiles = append(profiles, p)
 		}
 
@@ -162,7 +293,7 @@ func mergeSeriesSet(ctx context.Context, set storage.SeriesSet, maxMergeBatchSiz
 		default:
 		}
 
-		newAcc, err := profile.Merge(append([]*profile.Profile{acc}, profiles...))
+		newAcc, err := merge(append([]*profile.Profile{acc}, profiles...))
 		if err != nil {
 			return acc, count, err
 		}
@@ -187,5 +318,6 @@ func (a *API) MergeProfiles(r *http.Request) (*profile.Profile, storage.Warnings
 		r.URL.Query().Get("query"),
 		r.URL.Query().Get("from"),
 		r.URL.Query().Get("to"),
+		r.URL.Query().Get("merge_op"),
 	)
 }
@@ -3,10 +3,13 @@ package api
 import (
 	"context"
 	"io/ioutil"
+	"math"
 	"testing"
 
 	"github.com/conprof/db/storage"
+	"github.com/conprof/db/tsdb/chunkenc"
 	"github.com/conprof/db/tsdb/tsdbutil"
+	"github.com/google/pprof/profile"
 	"github.com/prometheus/prometheus/pkg/labels"
 	"github.com/stretchr/testify/require"
 )
@@ -56,7 +59,7 @@ func (s *sliceSeriesSet) Warnings() storage.Warnings {
 func TestBatchIteratorNoSeries(t *testing.T) {
 	set := newSliceSeriesSet([]storage.Series{})
 
-	i := newBatchIterator(set, 2)
+	i := newBatchIterator(set, math.MinInt64, math.MaxInt64, 2)
 	require.False(t, i.Next())
 }
 
@@ -65,7 +68,7 @@ func TestBatchIteratorNoSamples(t *testing.T) {
 		storage.NewListSeries(labels.Labels{{Name: "instance", Value: "a"}}, []tsdbutil.Sample{}),
 	})
 
-	i := newBatchIterator(set, 2)
+	i := newBatchIterator(set, math.MinInt64, math.MaxInt64, 2)
 	require.False(t, i.Next())
 }
 
@@ -80,7 +83,7 @@ func TestBatchIteratorSingleSeries(t *testing.T) {
 		}),
 	})
 
-	i := newBatchIterator(set, 2)
+	i := newBatchIterator(set, math.MinInt64, math.MaxInt64, 2)
 	require.True(t, i.Next())
 	require.EqualValues(t, [][]byte{[]byte("a"), []byte("b")}, i.Batch())
 	require.True(t, i.Next())
@@ -103,7 +106,7 @@ func TestBatchIteratorMultipleSeries(t *testing.T) {
 		}),
 	})
 
-	i := newBatchIterator(set, 2)
+	i := newBatchIterator(set, math.MinInt64, math.MaxInt64, 2)
 	require.True(t, i.Next())
 	require.EqualValues(t, [][]byte{[]byte("a"), []byte("b")}, i.Batch())
 	require.True(t, i.Next())
@@ -129,7 +132,7 @@ func TestMergeSeriesSet(t *testing.T) {
 		}),
 	})
 
-	_, _, err = mergeSeriesSet(context.Background(), set, 2)
+	_, _, err = mergeSeriesSet(context.Background(), set, math.MinInt64, math.MaxInt64, 2, profile.Merge, nil)
 	require.NoError(t, err)
 }
 
@@ -143,6 +146,177 @@ func TestMergeSeriesSetSingleSample(t *testing.T) {
 		}),
 	})
 
-	_, _, err = mergeSeriesSet(context.Background(), set, 2)
+	_, _, err = mergeSeriesSet(context.Background(), set, math.MinInt64, math.MaxInt64, 2, profile.Merge, nil)
 	require.NoError(t, err)
 }
+
+func TestMergeSeriesSetMax(t *testing.T) {
+	b, err := ioutil.ReadFile("testdata/alloc_objects.pb.gz")
+	require.NoError(t, err)
+
+	p, err := profile.ParseData(b)
+	require.NoError(t, err)
+	p.SampleType = p.SampleType[2:] // Only use the inuse (gauge) sample types.
+	for _, s := range p.Sample {
+		s.Value = s.Value[2:]
+	}
+	b, err = encodeProfile(p)
+	require.NoError(t, err)
+
+	newSet := func() storage.SeriesSet {
+		return newSliceSeriesSet([]storage.Series{
+			storage.NewListSeries(labels.Labels{{Name: "instance", Value: "a"}}, []tsdbutil.Sample{
+				&sample{t: 0, v: b},
+				&sample{t: 1, v: b},
+				&sample{t: 2, v: b},
+			}),
+		})
+	}
+
+	single, err := profile.Merge([]*profile.Profile{p})
+	require.NoError(t, err)
+
+	maxMerged, count, err := mergeSeriesSet(context.Background(), newSet(), math.MinInt64, math.MaxInt64, DefaultMergeBatchSize, mergeMax, nil)
+	require.NoError(t, err)
+	require.Equal(t, 2, count)
+	require.Equal(t, sampleTotals(single), sampleTotals(maxMerged))
+
+	sumMerged, _, err := mergeSeriesSet(context.Background(), newSet(), math.MinInt64, math.MaxInt64, DefaultMergeBatchSize, profile.Merge, nil)
+	require.NoError(t, err)
+	expected := sampleTotals(single)
+	for i := range expected {
+		expected[i] *= 3
+	}
+	require.Equal(t, expected, sampleTotals(sumMerged))
+}
+
+func TestMergeFuncByOp(t *testing.T) {
+	for _, op := range []string{"", MergeOpSum, MergeOpMax} {
+		_, err := mergeFuncByOp(op)
+		require.NoError(t, err)
+	}
+
+	_, err := mergeFuncByOp("avg")
+	require.Error(t, err)
+}
+
+func sampleTotals(p *profile.Profile) []int64 {
+	totals := make([]int64, len(p.SampleType))
+	for _, s := range p.Sample {
+		for i, v := range s.Value {
+			totals[i] += v
+		}
+	}
+	return totals
+}
+
+func TestMergeSeriesSetComments(t *testing.T) {
+	b, err := ioutil.ReadFile("testdata/comments.pb.gz")
+	require.NoError(t, err)
+
+	p, err := profile.ParseData(b)
+	require.NoError(t, err)
+	p.Comments = []string{"GOMAXPROCS=8", "GOMAXPROCS=16"}
+	other, err := encodeProfile(p)
+	require.NoError(t, err)
+
+	set := newSliceSeriesSet([]storage.Series{
+		storage.NewListSeries(labels.Labels{{Name: "instance", Value: "a"}}, []tsdbutil.Sample{
+			&sample{t: 0, v: b},
+			&sample{t: 1, v: other},
+		}),
+	})
+
+	merged, _, err := mergeSeriesSet(context.Background(), set, math.MinInt64, math.MaxInt64, DefaultMergeBatchSize, profile.Merge, nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"cmdline: /usr/bin/app --flag=value",
+		"go version: go1.15.6",
+		"GOMAXPROCS=8",
+		"GOMAXPROCS=16",
+	}, generateCommentsReport(merged))
+}
+
+func TestMergeSeriesSetTimeRange(t *testing.T) {
+	b, err := ioutil.ReadFile("testdata/alloc_objects.pb.gz")
+	require.NoError(t, err)
+
+	// Samples outside of the range are not valid profiles, decoding them
+	// would fail the merge.
+	set := newSliceSeriesSet([]storage.Series{
+		storage.NewListSeries(labels.Labels{{Name: "instance", Value: "a"}}, []tsdbutil.Sample{
+			&sample{t: 0, v: []byte("out of range")},
+			&sample{t: 1, v: b},
+			&sample{t: 2, v: b},
+			&sample{t: 3, v: b},
+			&sample{t: 4, v: []byte("out of range")},
+		}),
+		storage.NewListSeries(labels.Labels{{Name: "instance", Value: "b"}}, []tsdbutil.Sample{
+			&sample{t: 5, v: []byte("out of range")},
+		}),
+	})
+
+	_, count, err := mergeSeriesSet(context.Background(), set, 1, 3, DefaultMergeBatchSize, profile.Merge, nil)
+	require.NoError(t, err)
+	// The first profile is the base of the merge and not counted.
+	require.Equal(t, 2, count)
+}
+
+func TestBatchIteratorTimeRange(t *testing.T) {
+	set := newSliceSeriesSet([]storage.Series{
+		storage.NewListSeries(labels.Labels{{Name: "instance", Value: "a"}}, []tsdbutil.Sample{
+			&sample{t: 0, v: []byte("a")},
+			&sample{t: 2, v: []byte("b")},
+			&sample{t: 4, v: []byte("c")},
+			&sample{t: 6, v: []byte("d")},
+		}),
+		storage.NewListSeries(labels.Labels{{Name: "instance", Value: "b"}}, []tsdbutil.Sample{
+			&sample{t: 0, v: []byte("e")},
+		}),
+		storage.NewListSeries(labels.Labels{{Name: "instance", Value: "c"}}, []tsdbutil.Sample{
+			&sample{t: 3, v: []byte("f")},
+		}),
+	})
+
+	i := newBatchIterator(set, 1, 5, 2)
+	require.True(t, i.Next())
+	require.EqualValues(t, [][]byte{[]byte("b"), []byte("c")}, i.Batch())
+	require.True(t, i.Next())
+	require.EqualValues(t, [][]byte{[]byte("f")}, i.Batch())
+	require.False(t, i.Next())
+	require.NoError(t, i.Err())
+}
+
+// chunkSeries is a series backed by a single bytes chunk.
+type chunkSeries struct {
+	chunk chunkenc.Chunk
+}
+
+func (s *chunkSeries) Labels() labels.Labels {
+	return labels.Labels{{Name: "instance", Value: "a"}}
+}
+
+func (s *chunkSeries) Iterator() chunkenc.Iterator {
+	return s.chunk.Iterator(nil)
+}
+
+func BenchmarkMergeNarrowRangeWideChunk(b *testing.B) {
+	data, err := ioutil.ReadFile("testdata/alloc_objects.pb.gz")
+	require.NoError(b, err)
+
+	c := chunkenc.NewBytesChunk()
+	app, err := c.Appender()
+	require.NoError(b, err)
+	for i := int64(0); i < 1000; i++ {
+		app.Append(i, data)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		set := newSliceSeriesSet([]storage.Series{&chunkSeries{chunk: c}})
+		_, count, err := mergeSeriesSet(context.Background(), set, 500, 509, DefaultMergeBatchSize, profile.Merge, nil)
+		require.NoError(b, err)
+		require.Equal(b, 9, count)
+	}
+}
@@ -0,0 +1,51 @@
+// Copyright 2020 The conprof Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"math"
+	"time"
+
+	"github.com/google/pprof/profile"
+)
+
+type UnknownDurationError struct{}
+
+func (e *UnknownDurationError) Error() string {
+	return "profile duration is unknown, skipped normalization"
+}
+
+// hasKnownDuration returns whether the profile has a usable duration to
+// compute rates with.
+func hasKnownDuration(p *profile.Profile) bool {
+	return p.DurationNanos > 0
+}
+
+// normalizeByDuration scales all sample values of the profile to per second
+// rates. Profiles with an unknown duration are left untouched and a warning
+// is returned instead, as dividing by their duration would produce
+// meaningless results.
+func normalizeByDuration(p *profile.Profile) error {
+	if !hasKnownDuration(p) {
+		return &UnknownDurationError{}
+	}
+
+	ratio := float64(time.Second) / float64(p.DurationNanos)
+	if math.IsNaN(ratio) || math.IsInf(ratio, 0) {
+		return &UnknownDurationError{}
+	}
+
+	p.Scale(ratio)
+	return nil
+}
@@ -0,0 +1,133 @@
+// Copyright 2020 The conprof Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"math"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/conprof/db/storage"
+	"github.com/conprof/db/tsdb/tsdbutil"
+	"github.com/go-kit/kit/log"
+	"github.com/google/pprof/profile"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/stretchr/testify/require"
+
+	"github.com/conprof/conprof/pkg/testutil"
+)
+
+func TestNormalizeByDuration(t *testing.T) {
+	b, err := ioutil.ReadFile("testdata/alloc_objects.pb.gz")
+	require.NoError(t, err)
+
+	p, err := profile.ParseData(b)
+	require.NoError(t, err)
+	p.DurationNanos = int64(10 * time.Second)
+	expected := p.Sample[0].Value[0] / 10
+
+	require.NoError(t, normalizeByDuration(p))
+	require.Equal(t, expected, p.Sample[0].Value[0])
+}
+
+func TestNormalizeZeroDuration(t *testing.T) {
+	b, err := ioutil.ReadFile("testdata/alloc_objects.pb.gz")
+	require.NoError(t, err)
+
+	p, err := profile.ParseData(b)
+	require.NoError(t, err)
+	p.DurationNanos = 0
+	expected := p.Copy()
+
+	err = normalizeByDuration(p)
+	require.Error(t, err)
+	require.IsType(t, &UnknownDurationError{}, err)
+
+	// Values are left untouched rather than becoming NaN/Inf.
+	for i, s := range p.Sample {
+		require.Equal(t, expected.Sample[i].Value, s.Value)
+	}
+}
+
+func TestMergeUnknownDuration(t *testing.T) {
+	b, err := ioutil.ReadFile("testdata/alloc_objects.pb.gz")
+	require.NoError(t, err)
+
+	p, err := profile.ParseData(b)
+	require.NoError(t, err)
+	p.DurationNanos = int64(10 * time.Second)
+	withDuration, err := encodeProfile(p)
+	require.NoError(t, err)
+
+	p.DurationNanos = 0
+	withoutDuration, err := encodeProfile(p)
+	require.NoError(t, err)
+
+	set := newSliceSeriesSet([]storage.Series{
+		storage.NewListSeries(labels.Labels{{Name: "instance", Value: "a"}}, []tsdbutil.Sample{
+			&sample{t: 0, v: withDuration},
+			&sample{t: 1, v: withoutDuration},
+		}),
+	})
+
+	merged, _, err := mergeSeriesSet(context.Background(), set, math.MinInt64, math.MaxInt64, DefaultMergeBatchSize, profile.Merge, nil)
+	require.NoError(t, err)
+	require.False(t, hasKnownDuration(merged))
+
+	err = normalizeByDuration(merged)
+	require.IsType(t, &UnknownDurationError{}, err)
+}
+
+func encodeProfile(p *profile.Profile) ([]byte, error) {
+	buf := bytes.NewBuffer(nil)
+	if err := p.Write(buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func TestAPIQueryNormalizeZeroDuration(t *testing.T) {
+	db, err := testutil.NewTSDB()
+	require.NoError(t, err)
+	defer db.Close()
+
+	// The fixture was captured without a duration.
+	b, err := ioutil.ReadFile("testdata/alloc_objects.pb.gz")
+	require.NoError(t, err)
+
+	app := db.Appender(context.Background())
+	_, err = app.Add(labels.Labels{{Name: "__name__", Value: "allocs"}}, 1, b)
+	require.NoError(t, err)
+	require.NoError(t, app.Commit())
+
+	api := New(log.NewNopLogger(), prometheus.NewRegistry(), WithDB(db), WithQueryTimeout(time.Minute))
+	resp, warn, apiErr := executeEndpoint(t, endpointTestCase{
+		endpoint: api.Query,
+		query: url.Values{
+			"mode":      []string{"single"},
+			"query":     []string{"allocs"},
+			"time":      []string{"1"},
+			"normalize": []string{"true"},
+		},
+	})
+	require.Nil(t, apiErr)
+	require.Equal(t, 1, len(warn))
+	require.IsType(t, &UnknownDurationError{}, warn[0])
+	require.NotNil(t, resp.(*ProfileResponseRenderer).profile)
+}
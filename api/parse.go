@@ -0,0 +1,62 @@
+// Copyright 2020 The conprof Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"context"
+
+	"github.com/google/pprof/profile"
+)
+
+// parseGate bounds the number of profiles parsed concurrently, independent
+// of how many requests are being served. A nil gate doesn't limit parsing.
+type parseGate chan struct{}
+
+func newParseGate(max int) parseGate {
+	if max <= 0 {
+		return nil
+	}
+	return make(parseGate, max)
+}
+
+// start blocks until a parse may start or the context is done.
+func (g parseGate) start(ctx context.Context) error {
+	if g == nil {
+		return nil
+	}
+	select {
+	case g <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// done releases a slot acquired by start.
+func (g parseGate) done() {
+	if g == nil {
+		return
+	}
+	<-g
+}
+
+// parse decodes a profile once the gate admits it.
+func (g parseGate) parse(ctx context.Context, b []byte) (*profile.Profile, error) {
+	if err := g.start(ctx); err != nil {
+		return nil, err
+	}
+	defer g.done()
+
+	return profile.ParseData(b)
+}
@@ -0,0 +1,87 @@
+// Copyright 2020 The conprof Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"context"
+	"io/ioutil"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseGateLimitsConcurrency(t *testing.T) {
+	const limit = 3
+	g := newParseGate(limit)
+
+	var (
+		wg               sync.WaitGroup
+		running, maxSeen int64
+	)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			require.NoError(t, g.start(context.Background()))
+			defer g.done()
+
+			n := atomic.AddInt64(&running, 1)
+			for {
+				max := atomic.LoadInt64(&maxSeen)
+				if n <= max || atomic.CompareAndSwapInt64(&maxSeen, max, n) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+			atomic.AddInt64(&running, -1)
+		}()
+	}
+	wg.Wait()
+
+	require.True(t, maxSeen <= limit, "saw %d concurrent parses, limit is %d", maxSeen, limit)
+	require.True(t, maxSeen > 1, "expected parses to run concurrently")
+}
+
+func TestParseGateCancellation(t *testing.T) {
+	b, err := ioutil.ReadFile("testdata/alloc_objects.pb.gz")
+	require.NoError(t, err)
+
+	g := newParseGate(1)
+	// Occupy the only slot.
+	require.NoError(t, g.start(context.Background()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = g.parse(ctx, b)
+	require.Equal(t, context.DeadlineExceeded, err)
+
+	g.done()
+	p, err := g.parse(context.Background(), b)
+	require.NoError(t, err)
+	require.NotNil(t, p)
+}
+
+func TestParseGateUnlimited(t *testing.T) {
+	b, err := ioutil.ReadFile("testdata/alloc_objects.pb.gz")
+	require.NoError(t, err)
+
+	var g parseGate
+	require.Nil(t, newParseGate(0))
+	p, err := g.parse(context.Background(), b)
+	require.NoError(t, err)
+	require.NotNil(t, p)
+}
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"fmt"
 	"net/http"
 
 	"github.com/go-kit/kit/log"
@@ -29,47 +30,57 @@ func NewProfileResponseRenderer(
 }
 
 func (r *ProfileResponseRenderer) Render(w http.ResponseWriter) error {
+	p, sampleIndex, err := applySampleIndex(r.profile, r.req.URL.Query().Get("sample_index"))
+	if err != nil {
+		return err
+	}
+
 	switch r.req.URL.Query().Get("report") {
 	case "meta":
-		meta, err := GenerateMetaReport(r.profile)
+		meta, err := GenerateMetaReport(p)
 		if err != nil {
 			return err
 		}
 
 		return NewSuccessResponse(meta, r.warnings).Render(w)
 	case "top":
-		top, err := generateTopReport(r.profile, r.req.URL.Query().Get("sample_index"))
+		top, err := generateTopReport(p, sampleIndex)
 		if err != nil {
 			return err
 		}
 
 		return NewSuccessResponse(top, r.warnings).Render(w)
 	case "flamegraph":
-		fg, err := generateFlamegraphReport(r.profile, r.req.URL.Query().Get("sample_index"))
+		fg, err := generateFlamegraphReport(p, sampleIndex)
 		if err != nil {
 			return err
 		}
 
 		return NewSuccessResponse(fg, r.warnings).Render(w)
+	case "comments":
+		return NewSuccessResponse(generateCommentsReport(p), r.warnings).Render(w)
 	case "proto":
-		return NewProtoRenderer(r.profile).Render(w)
+		return NewProtoRenderer(p, r.req.URL.Query().Get("compress")).Render(w)
 	case "svg":
 		return NewSVGRenderer(
 			r.logger,
-			r.profile,
-			r.req.URL.Query().Get("sample_index"),
+			p,
+			sampleIndex,
 		).Render(w)
 	default:
 		return NewSVGRenderer(
 			r.logger,
-			r.profile,
-			r.req.URL.Query().Get("sample_index"),
+			p,
+			sampleIndex,
 		).Render(w)
 	}
 }
 
 type ValueType struct {
 	Type string `json:"type,omitempty"`
+	// Estimated is set for sample types derived from other sample types
+	// rather than recorded.
+	Estimated bool `json:"estimated,omitempty"`
 }
 
 type MetaReport struct {
@@ -88,26 +99,57 @@ func GenerateMetaReport(profile *profile.Profile) (*MetaReport, error) {
 		DefaultSampleType: profile.SampleType[index].Type,
 	}
 	for _, t := range profile.SampleType {
-		res.SampleTypes = append(res.SampleTypes, ValueType{t.Type})
+		res.SampleTypes = append(res.SampleTypes, ValueType{
+			Type:      t.Type,
+			Estimated: isEstimatedSampleType(t.Type),
+		})
 	}
 
 	return res, nil
 }
 
+// generateCommentsReport returns the free-form comments embedded in the
+// profile. Merged profiles already carry the union of all comments.
+func generateCommentsReport(profile *profile.Profile) []string {
+	comments := make([]string, 0, len(profile.Comments))
+	return append(comments, profile.Comments...)
+}
+
+const (
+	CompressGzip = "gzip"
+	CompressNone = "none"
+)
+
 type ProtoRenderer struct {
-	profile *profile.Profile
+	profile  *profile.Profile
+	compress string
 }
 
-func NewProtoRenderer(profile *profile.Profile) *ProtoRenderer {
-	return &ProtoRenderer{profile: profile}
+// NewProtoRenderer returns a renderer writing the profile as protobuf. The
+// compress parameter is either "gzip" or "none", an empty value defaults to
+// gzip as that is what pprof expects.
+func NewProtoRenderer(profile *profile.Profile, compress string) *ProtoRenderer {
+	if compress == "" {
+		compress = CompressGzip
+	}
+	return &ProtoRenderer{profile: profile, compress: compress}
 }
 
 func (r *ProtoRenderer) Render(w http.ResponseWriter) error {
-	w.Header().Set("Content-Type", "application/vnd.google.protobuf+gzip")
-	w.Header().Set("Content-Disposition", "attachment;filename=profile.pb.gz")
-	err := r.profile.Write(w)
-	if err != nil {
-		return err
+	switch r.compress {
+	case CompressGzip:
+		w.Header().Set("Content-Type", "application/vnd.google.protobuf+gzip")
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Set("Content-Disposition", "attachment;filename=profile.pb.gz")
+		return r.profile.Write(w)
+	case CompressNone:
+		w.Header().Set("Content-Type", "application/vnd.google.protobuf")
+		// Explicitly set identity so the response is not compressed by any
+		// middleware.
+		w.Header().Set("Content-Encoding", "identity")
+		w.Header().Set("Content-Disposition", "attachment;filename=profile.pb")
+		return r.profile.WriteUncompressed(w)
+	default:
+		return &ApiError{Typ: ErrorBadData, Err: fmt.Errorf("unknown compression %q, valid options are %q and %q", r.compress, CompressGzip, CompressNone)}
 	}
-	return nil
 }
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"encoding/json"
 	"errors"
 	"io/ioutil"
 	"net/http"
@@ -157,3 +158,109 @@ func tryRender(t *testing.T, r renderer, w http.ResponseWriter) {
 
 	require.NoError(t, err)
 }
+
+func TestRenderProtoCompression(t *testing.T) {
+	b, err := ioutil.ReadFile("testdata/alloc_objects.pb.gz")
+	require.NoError(t, err)
+
+	p, err := profile.ParseData(b)
+	require.NoError(t, err)
+
+	for _, c := range []struct {
+		compress        string
+		contentEncoding string
+		gzipped         bool
+	}{
+		{compress: "", contentEncoding: "gzip", gzipped: true},
+		{compress: "gzip", contentEncoding: "gzip", gzipped: true},
+		{compress: "none", contentEncoding: "identity", gzipped: false},
+	} {
+		t.Run(c.compress, func(t *testing.T) {
+			v := url.Values{}
+			v.Set("report", "proto")
+			v.Set("compress", c.compress)
+			u := &url.URL{
+				Scheme:   "http",
+				Host:     "example.com",
+				RawQuery: v.Encode(),
+			}
+			req := httptest.NewRequest("GET", u.String(), nil)
+
+			r := NewProfileResponseRenderer(
+				log.NewNopLogger(),
+				p,
+				nil,
+				req,
+			)
+
+			w := httptest.NewRecorder()
+			require.NoError(t, r.Render(w))
+
+			res := w.Result()
+			require.Equal(t, http.StatusOK, res.StatusCode)
+			require.Equal(t, c.contentEncoding, res.Header.Get("Content-Encoding"))
+
+			body := w.Body.Bytes()
+			// Gzip magic number.
+			require.Equal(t, c.gzipped, len(body) > 2 && body[0] == 0x1f && body[1] == 0x8b)
+
+			_, err := profile.ParseData(body)
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestRenderProtoUnknownCompression(t *testing.T) {
+	b, err := ioutil.ReadFile("testdata/alloc_objects.pb.gz")
+	require.NoError(t, err)
+
+	p, err := profile.ParseData(b)
+	require.NoError(t, err)
+
+	err = NewProtoRenderer(p, "zstd").Render(httptest.NewRecorder())
+	require.Error(t, err)
+
+	apiErr, ok := err.(*ApiError)
+	require.True(t, ok)
+	require.Equal(t, ErrorBadData, apiErr.Typ)
+}
+
+func TestRenderComments(t *testing.T) {
+	b, err := ioutil.ReadFile("testdata/comments.pb.gz")
+	require.NoError(t, err)
+
+	p, err := profile.ParseData(b)
+	require.NoError(t, err)
+
+	v := url.Values{}
+	v.Set("report", "comments")
+	u := &url.URL{
+		Scheme:   "http",
+		Host:     "example.com",
+		RawQuery: v.Encode(),
+	}
+	req := httptest.NewRequest("GET", u.String(), nil)
+
+	r := NewProfileResponseRenderer(
+		log.NewNopLogger(),
+		p,
+		nil,
+		req,
+	)
+
+	w := httptest.NewRecorder()
+	require.NoError(t, r.Render(w))
+
+	res := w.Result()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	var body struct {
+		Data []string `json:"data"`
+	}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&body))
+	require.Equal(t, []string{
+		"cmdline: /usr/bin/app --flag=value",
+		"go version: go1.15.6",
+		"GOMAXPROCS=8",
+	}, body.Data)
+}
@@ -0,0 +1,85 @@
+// Copyright 2020 The conprof Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package requestid propagates request IDs from incoming HTTP requests to
+// logs, responses and outgoing gRPC calls.
+package requestid
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/oklog/ulid"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	// HeaderName is the HTTP header carrying the request ID.
+	HeaderName = "X-Request-ID"
+	// MetadataKey is the gRPC metadata key carrying the request ID.
+	MetadataKey = "x-request-id"
+)
+
+type ctxKey int
+
+const reqIDKey = ctxKey(0)
+
+var (
+	entropyMtx sync.Mutex
+	entropy    = ulid.Monotonic(rand.New(rand.NewSource(time.Now().UnixNano())), 0)
+)
+
+// NewContext returns a context carrying the request ID.
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, reqIDKey, id)
+}
+
+// FromContext returns the request ID stored in the context, if any.
+func FromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(reqIDKey).(string)
+	return id, ok && id != ""
+}
+
+// New generates a new unique request ID.
+func New() string {
+	entropyMtx.Lock()
+	defer entropyMtx.Unlock()
+	return ulid.MustNew(ulid.Timestamp(time.Now()), entropy).String()
+}
+
+// Handler reads the request ID of incoming requests, or generates one if
+// absent, attaches it to the request context and echoes it back as a
+// response header.
+func Handler(h http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(HeaderName)
+		if id == "" {
+			id = New()
+		}
+		w.Header().Set(HeaderName, id)
+		h.ServeHTTP(w, r.WithContext(NewContext(r.Context(), id)))
+	}
+}
+
+// AppendToOutgoingContext adds the request ID of the context, if any, to
+// the outgoing gRPC metadata.
+func AppendToOutgoingContext(ctx context.Context) context.Context {
+	id, ok := FromContext(ctx)
+	if !ok {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, MetadataKey, id)
+}
@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"io"
 
+	"github.com/conprof/conprof/pkg/requestid"
 	"github.com/conprof/conprof/pkg/store/storepb"
 	"github.com/conprof/db/storage"
 	"github.com/conprof/db/tsdb/chunkenc"
@@ -60,7 +61,7 @@ func (q *grpcStoreQuerier) Select(sortSeries bool, hints *storage.SelectHints, m
 		return ss
 	}
 
-	stream, err := q.c.Series(q.ctx, &storepb.SeriesRequest{
+	stream, err := q.c.Series(requestid.AppendToOutgoingContext(q.ctx), &storepb.SeriesRequest{
 		MinTime:     q.mint,
 		MaxTime:     q.maxt,
 		Matchers:    m,
@@ -139,16 +140,26 @@ func (s *rawChunkIterator) Next() bool {
 }
 
 func (s *rawChunkIterator) Seek(t int64) bool {
-	for i, c := range s.chunks {
-		if c.MinTime <= t && c.MaxTime >= t {
-			s.pos = i
-			c, err := chunkenc.FromData(chunkenc.EncBytes, s.chunks[s.pos].Raw.Data)
-			if err != nil {
-				s.err = fmt.Errorf("decode chunk: %w", err)
-				return false
-			}
-			s.curIt = c.Iterator(nil)
-			return s.curIt.Seek(t)
+	if s.curIt != nil && s.chunks[s.pos].MaxTime >= t && s.curIt.Seek(t) {
+		return true
+	}
+
+	for (s.pos + 1) < len(s.chunks) {
+		s.pos++
+		if s.chunks[s.pos].MaxTime < t {
+			// Skip chunks ending before t without decoding them.
+			s.curIt = nil
+			continue
+		}
+
+		c, err := chunkenc.FromData(chunkenc.EncBytes, s.chunks[s.pos].Raw.Data)
+		if err != nil {
+			s.err = fmt.Errorf("decode chunk: %w", err)
+			return false
+		}
+		s.curIt = c.Iterator(nil)
+		if s.curIt.Seek(t) {
+			return true
 		}
 	}
 	return false
@@ -175,7 +186,7 @@ func (s *grpcSeriesSet) Warnings() storage.Warnings {
 }
 
 func (q *grpcStoreQuerier) LabelValues(name string) ([]string, storage.Warnings, error) {
-	resp, err := q.c.LabelValues(q.ctx, &storepb.LabelValuesRequest{
+	resp, err := q.c.LabelValues(requestid.AppendToOutgoingContext(q.ctx), &storepb.LabelValuesRequest{
 		Label: name,
 		Start: q.mint,
 		End:   q.maxt,
@@ -193,7 +204,7 @@ func (q *grpcStoreQuerier) LabelValues(name string) ([]string, storage.Warnings,
 }
 
 func (q *grpcStoreQuerier) LabelNames() ([]string, storage.Warnings, error) {
-	resp, err := q.c.LabelNames(q.ctx, &storepb.LabelNamesRequest{
+	resp, err := q.c.LabelNames(requestid.AppendToOutgoingContext(q.ctx), &storepb.LabelNamesRequest{
 		Start: q.mint,
 		End:   q.maxt,
 	})
@@ -16,8 +16,10 @@ package store
 import (
 	"context"
 	"net"
+	"reflect"
 	"testing"
 
+	"github.com/conprof/conprof/pkg/requestid"
 	"github.com/conprof/conprof/pkg/store/storepb"
 	"github.com/conprof/db/tsdb/chunkenc"
 	"github.com/gogo/status"
@@ -25,6 +27,7 @@ import (
 	"github.com/thanos-io/thanos/pkg/store/labelpb"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 )
 
 type fakeProfileStore struct{}
@@ -117,3 +120,111 @@ func TestAPIQueryRangeGRPCCall(t *testing.T) {
 		t.Fatal("Expected a next series, but didn't get any")
 	}
 }
+
+type requestIDProfileStore struct {
+	fakeProfileStore
+	requestIDs []string
+}
+
+func (s *requestIDProfileStore) Series(r *storepb.SeriesRequest, srv storepb.ReadableProfileStore_SeriesServer) error {
+	md, _ := metadata.FromIncomingContext(srv.Context())
+	s.requestIDs = md.Get(requestid.MetadataKey)
+	return s.fakeProfileStore.Series(r, srv)
+}
+
+func TestGRPCQueryableRequestID(t *testing.T) {
+	lis, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer lis.Close()
+	grpcServer := grpc.NewServer()
+	s := &requestIDProfileStore{}
+	storepb.RegisterReadableProfileStoreServer(grpcServer, s)
+	go grpcServer.Serve(lis)
+
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := NewGRPCQueryable(storepb.NewReadableProfileStoreClient(conn))
+
+	qr, err := q.Querier(requestid.NewContext(context.Background(), "test-id"), 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ss := qr.Select(false, nil, labels.MustNewMatcher(labels.MatchEqual, "__name__", "allocs"))
+	for ss.Next() {
+	}
+	if err := ss.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(s.requestIDs) != 1 || s.requestIDs[0] != "test-id" {
+		t.Fatalf("expected request ID to be propagated, got %v", s.requestIDs)
+	}
+}
+
+func TestRawChunkIteratorSeek(t *testing.T) {
+	newChunk := func(ts ...int64) storepb.AggrChunk {
+		c := chunkenc.NewBytesChunk()
+		app, err := c.Appender()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, t := range ts {
+			app.Append(t, []byte{byte(t)})
+		}
+		b, err := c.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return storepb.AggrChunk{
+			MinTime: ts[0],
+			MaxTime: ts[len(ts)-1],
+			Raw:     &storepb.Chunk{Type: 1, Data: b},
+		}
+	}
+
+	s := &protoSeries{chunks: []storepb.AggrChunk{
+		newChunk(1, 2, 3),
+		// Not a valid chunk, seeking past it must not decode it.
+		{MinTime: 4, MaxTime: 6, Raw: &storepb.Chunk{Type: 1, Data: []byte{0xff}}},
+		newChunk(10, 11, 12),
+		newChunk(20, 21),
+	}}
+
+	it := s.Iterator()
+	// Seeking into the gap between chunks lands on the next chunk.
+	if !it.Seek(8) {
+		t.Fatalf("expected seek to succeed, err: %v", it.Err())
+	}
+	if ts, _ := it.At(); ts != 10 {
+		t.Fatalf("expected timestamp 10, got %d", ts)
+	}
+
+	// Seeking to an earlier timestamp has no effect.
+	if !it.Seek(2) {
+		t.Fatal("expected seek to succeed")
+	}
+	if ts, _ := it.At(); ts != 10 {
+		t.Fatalf("expected timestamp 10, got %d", ts)
+	}
+
+	var res []int64
+	for it.Next() {
+		ts, _ := it.At()
+		res = append(res, ts)
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(res, []int64{11, 12, 20, 21}) {
+		t.Fatalf("unexpected timestamps %v", res)
+	}
+
+	if it.Seek(22) {
+		t.Fatal("expected seek past the last sample to fail")
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright 2020 The conprof Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/conprof/conprof/pkg/store/storepb"
+	"github.com/conprof/db/tsdb"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// blockReader is implemented by databases that expose their persisted
+// blocks, such as *tsdb.DB. It is used to invalidate cached label values
+// whenever blocks are created or deleted.
+type blockReader interface {
+	Blocks() []*tsdb.Block
+}
+
+type labelValuesKey struct {
+	name       string
+	start, end int64
+}
+
+type labelValuesEntry struct {
+	resp    *storepb.LabelValuesResponse
+	created time.Time
+}
+
+// labelValuesCache holds the union of label values across all blocks per
+// label name and time range, so repeated lookups don't scan every block.
+// Entries expire after the TTL, and the whole cache is dropped when the set
+// of blocks changes.
+type labelValuesCache struct {
+	ttl time.Duration
+	now func() time.Time
+
+	mtx     sync.Mutex
+	blocks  string
+	entries map[labelValuesKey]labelValuesEntry
+}
+
+func newLabelValuesCache(reg prometheus.Registerer, ttl time.Duration) *labelValuesCache {
+	c := &labelValuesCache{
+		ttl:     ttl,
+		now:     time.Now,
+		entries: map[labelValuesKey]labelValuesEntry{},
+	}
+	promauto.With(reg).NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "conprof_store_label_values_cache_age_seconds",
+		Help: "Age of the oldest entry in the label values cache.",
+	}, c.age)
+	return c
+}
+
+// get returns the cached response for the request, if one exists that is
+// younger than the TTL and was computed against the given blocks.
+func (c *labelValuesCache) get(blocks string, r *storepb.LabelValuesRequest) (*storepb.LabelValuesResponse, bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if blocks != c.blocks {
+		c.blocks = blocks
+		c.entries = map[labelValuesKey]labelValuesEntry{}
+		return nil, false
+	}
+
+	k := labelValuesKey{name: r.Label, start: r.Start, end: r.End}
+	e, ok := c.entries[k]
+	if !ok {
+		return nil, false
+	}
+	if c.now().Sub(e.created) >= c.ttl {
+		delete(c.entries, k)
+		return nil, false
+	}
+	return e.resp, true
+}
+
+func (c *labelValuesCache) set(blocks string, r *storepb.LabelValuesRequest, resp *storepb.LabelValuesResponse) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if blocks != c.blocks {
+		// Blocks changed while the values were computed, they may already be stale.
+		return
+	}
+
+	// Label values are only valid for the lifetime of the querier that
+	// returned them, so the cache needs its own copy.
+	values := make([]string, 0, len(resp.Values))
+	for _, v := range resp.Values {
+		values = append(values, string([]byte(v)))
+	}
+	c.entries[labelValuesKey{name: r.Label, start: r.Start, end: r.End}] = labelValuesEntry{
+		resp: &storepb.LabelValuesResponse{
+			Values:   values,
+			Warnings: resp.Warnings,
+		},
+		created: c.now(),
+	}
+}
+
+func (c *labelValuesCache) age() float64 {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	now := c.now()
+	var oldest time.Duration
+	for _, e := range c.entries {
+		if a := now.Sub(e.created); a > oldest {
+			oldest = a
+		}
+	}
+	return oldest.Seconds()
+}
+
+// blocksFingerprint identifies the current set of blocks of the database, it
+// is empty if the database doesn't expose its blocks.
+func blocksFingerprint(db interface{}) string {
+	br, ok := db.(blockReader)
+	if !ok {
+		return ""
+	}
+
+	var b strings.Builder
+	for _, blk := range br.Blocks() {
+		b.WriteString(blk.Meta().ULID.String())
+	}
+	return b.String()
+}
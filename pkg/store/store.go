@@ -18,6 +18,7 @@ import (
 	"errors"
 	"fmt"
 	"sort"
+	"time"
 
 	"github.com/conprof/conprof/pkg/runutil"
 	"github.com/conprof/conprof/pkg/store/storepb"
@@ -25,6 +26,7 @@ import (
 	"github.com/conprof/db/tsdb"
 	"github.com/conprof/db/tsdb/chunkenc"
 	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/prometheus/pkg/labels"
 	"github.com/thanos-io/thanos/pkg/store/labelpb"
 	"go.opentelemetry.io/otel"
@@ -46,6 +48,22 @@ type profileStore struct {
 	logger           log.Logger
 	db               db
 	maxBytesPerFrame int
+
+	labelValuesCache *labelValuesCache
+}
+
+type ProfileStoreOption func(*profileStore)
+
+// WithLabelValuesCache caches label values responses for the given TTL. The
+// cache is invalidated whenever blocks are created or deleted. A TTL of zero
+// or less disables caching.
+func WithLabelValuesCache(reg prometheus.Registerer, ttl time.Duration) ProfileStoreOption {
+	return func(s *profileStore) {
+		if ttl <= 0 {
+			return
+		}
+		s.labelValuesCache = newLabelValuesCache(reg, ttl)
+	}
 }
 
 func RegisterReadableStoreServer(storeSrv storepb.ReadableProfileStoreServer) func(*grpc.Server) {
@@ -60,12 +78,16 @@ func RegisterWritableStoreServer(storeSrv storepb.WritableProfileStoreServer) fu
 	}
 }
 
-func NewProfileStore(logger log.Logger, db db, maxBytesPerFrame int) *profileStore {
-	return &profileStore{
+func NewProfileStore(logger log.Logger, db db, maxBytesPerFrame int, opts ...ProfileStoreOption) *profileStore {
+	s := &profileStore{
 		logger:           logger,
 		db:               db,
 		maxBytesPerFrame: maxBytesPerFrame,
 	}
+	for _, o := range opts {
+		o(s)
+	}
+	return s
 }
 
 var _ storepb.ReadableProfileStoreServer = &profileStore{}
@@ -290,6 +312,14 @@ func (s *profileStore) LabelNames(ctx context.Context, r *storepb.LabelNamesRequ
 }
 
 func (s *profileStore) LabelValues(ctx context.Context, r *storepb.LabelValuesRequest) (*storepb.LabelValuesResponse, error) {
+	var blocks string
+	if s.labelValuesCache != nil {
+		blocks = blocksFingerprint(s.db)
+		if resp, ok := s.labelValuesCache.get(blocks, r); ok {
+			return resp, nil
+		}
+	}
+
 	q, err := s.db.Querier(ctx, r.Start, r.End)
 	if err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
@@ -303,10 +333,14 @@ func (s *profileStore) LabelValues(ctx context.Context, r *storepb.LabelValuesRe
 		warningStrings = append(warningStrings, w.Error())
 	}
 
-	return &storepb.LabelValuesResponse{
+	resp := &storepb.LabelValuesResponse{
 		Values:   labelNames,
 		Warnings: warningStrings,
-	}, err
+	}
+	if err == nil && s.labelValuesCache != nil {
+		s.labelValuesCache.set(blocks, r, resp)
+	}
+	return resp, err
 }
 
 func translatePbMatchers(ms []storepb.LabelMatcher) (res []*labels.Matcher, err error) {
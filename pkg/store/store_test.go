@@ -19,6 +19,7 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"math"
 	"net"
 	"net/http/httptest"
 	"os"
@@ -30,6 +31,7 @@ import (
 	"github.com/conprof/conprof/pkg/store/storepb"
 	"github.com/conprof/db/storage"
 	"github.com/conprof/db/tsdb"
+	"github.com/conprof/db/tsdb/tsdbutil"
 	"github.com/conprof/db/tsdb/wal"
 	"github.com/go-kit/kit/log"
 	"github.com/prometheus/client_golang/prometheus"
@@ -272,3 +274,191 @@ func TestStore(t *testing.T) {
 		t.Fatalf("Unexpected timestamps, expected %s, got %s", fmt.Sprintf("%#+v", expectedTimestamps), fmt.Sprintf("%#+v", res.Timestamps))
 	}
 }
+
+type countingLabelQueryable struct {
+	fakeAppender
+
+	scans  int
+	values []string
+}
+
+func (q *countingLabelQueryable) Querier(ctx context.Context, mint, maxt int64) (storage.Querier, error) {
+	return q, nil
+}
+
+func (q *countingLabelQueryable) Select(sortSeries bool, hints *storage.SelectHints, matchers ...*labels.Matcher) storage.SeriesSet {
+	return storage.EmptySeriesSet()
+}
+
+func (q *countingLabelQueryable) LabelValues(name string) ([]string, storage.Warnings, error) {
+	q.scans++
+	return q.values, nil, nil
+}
+
+func (q *countingLabelQueryable) LabelNames() ([]string, storage.Warnings, error) {
+	return nil, nil, nil
+}
+
+func (q *countingLabelQueryable) Close() error {
+	return nil
+}
+
+func TestStoreLabelValuesCache(t *testing.T) {
+	db := &countingLabelQueryable{values: []string{"allocs"}}
+	s := NewProfileStore(log.NewNopLogger(), db, 100000, WithLabelValuesCache(prometheus.NewRegistry(), time.Minute))
+
+	now := time.Unix(0, 0)
+	s.labelValuesCache.now = func() time.Time { return now }
+
+	req := &storepb.LabelValuesRequest{Label: "__name__", Start: 0, End: 10}
+	for i := 0; i < 2; i++ {
+		resp, err := s.LabelValues(context.Background(), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(resp.Values, []string{"allocs"}) {
+			t.Fatalf("unexpected values %v", resp.Values)
+		}
+	}
+	if db.scans != 1 {
+		t.Fatalf("expected second call within TTL to be served from cache, got %d scans", db.scans)
+	}
+
+	// New writes only show up once the cached entry expired.
+	db.values = []string{"allocs", "heap"}
+	resp, err := s.LabelValues(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(resp.Values, []string{"allocs"}) {
+		t.Fatalf("unexpected values %v", resp.Values)
+	}
+
+	now = now.Add(time.Minute)
+	resp, err = s.LabelValues(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(resp.Values, []string{"allocs", "heap"}) {
+		t.Fatalf("unexpected values %v", resp.Values)
+	}
+	if db.scans != 2 {
+		t.Fatalf("expected expired entry to be recomputed, got %d scans", db.scans)
+	}
+}
+
+// TestStoreQueryDuringRetention ensures deleting blocks beyond retention
+// doesn't block queries. The TSDB swaps the block list under a short lock and
+// only then closes obsolete blocks, which waits for their pending readers.
+func TestStoreQueryDuringRetention(t *testing.T) {
+	dir, err := ioutil.TempDir("", "conprof-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	hour := time.Hour.Milliseconds()
+	oldBlock, err := tsdb.CreateBlock([]storage.Series{
+		storage.NewListSeries(labels.FromStrings("__name__", "allocs"), []tsdbutil.Sample{
+			testSample{t: 0, v: []byte("old")},
+		}),
+	}, dir, 0, log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := tsdb.Open(dir, log.NewNopLogger(), prometheus.NewRegistry(), &tsdb.Options{
+		RetentionDuration:      hour,
+		WALSegmentSize:         wal.DefaultSegmentSize,
+		MinBlockDuration:       tsdb.DefaultBlockDuration,
+		MaxBlockDuration:       tsdb.DefaultBlockDuration,
+		NoLockfile:             true,
+		AllowOverlappingBlocks: false,
+		StripeSize:             tsdb.DefaultStripeSize,
+	})
+	if err != nil {
+		t.Fatalf("failed to open tsdb: %v", err)
+	}
+	defer db.Close()
+
+	// Keep a reader on the old block, so deleting it has to wait.
+	pending, err := db.Querier(context.Background(), 0, hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := db.Appender(context.Background())
+	if _, err := app.Add(labels.FromStrings("__name__", "allocs"), 20*hour, []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Persisting the head reloads the blocks, which enforces retention.
+	gcDone := make(chan error)
+	go func() {
+		gcDone <- db.CompactHead(tsdb.NewRangeHead(db.Head(), 20*hour, 21*hour-1))
+	}()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		blocks := db.Blocks()
+		if len(blocks) == 1 && blocks[0].Dir() != oldBlock {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("old block was not removed from the block list")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	s := NewProfileStore(log.NewNopLogger(), db, 100000)
+	queryDone := make(chan error)
+	go func() {
+		_, err := s.LabelValues(context.Background(), &storepb.LabelValuesRequest{
+			Label: "__name__",
+			Start: math.MinInt64,
+			End:   math.MaxInt64,
+		})
+		queryDone <- err
+	}()
+
+	select {
+	case err := <-queryDone:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("query blocked by retention")
+	}
+
+	select {
+	case <-gcDone:
+		t.Fatal("expected deletion to wait for the pending reader")
+	default:
+	}
+
+	if err := pending.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-gcDone; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(oldBlock); !os.IsNotExist(err) {
+		t.Fatalf("expected old block to be deleted, got %v", err)
+	}
+}
+
+type testSample struct {
+	t int64
+	v []byte
+}
+
+func (s testSample) T() int64 {
+	return s.t
+}
+
+func (s testSample) V() []byte {
+	return s.v
+}
@@ -466,6 +466,8 @@ func newScrapeLoop(ctx context.Context,
 }
 
 func (sl *scrapeLoop) run(interval, timeout time.Duration, errc chan<- error) {
+	sl.target.setScrapeInterval(interval)
+
 	select {
 	case <-time.After(sl.scraper.offset(interval)):
 		// Continue after a scraping offset.
@@ -541,26 +543,18 @@ mainLoop:
 				level.Debug(sl.l).Log("err", err)
 				errc <- err
 			}
-
-			sl.target.health = HealthGood
-			sl.target.lastScrapeDuration = time.Since(start)
-			sl.target.lastError = nil
 		} else {
 			level.Debug(sl.l).Log("msg", "Scrape failed", "err", scrapeErr.Error())
 			if errc != nil {
 				errc <- scrapeErr
 			}
-
-			sl.target.health = HealthBad
-			sl.target.lastScrapeDuration = time.Since(start)
-			sl.target.lastError = scrapeErr
 		}
 
+		sl.target.report(start, time.Since(start), scrapeErr)
+
 		sl.buffers.Put(b)
 		last = start
 
-		sl.target.lastScrape = last
-
 		select {
 		case <-sl.ctx.Done():
 			close(sl.stopped)
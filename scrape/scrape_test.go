@@ -0,0 +1,93 @@
+// Copyright 2020 The conprof Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scrape
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/conprof/db/storage"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/stretchr/testify/require"
+)
+
+type nopAppendable struct{}
+
+func (a nopAppendable) Appender(_ context.Context) storage.Appender {
+	return a
+}
+
+func (a nopAppendable) Add(l labels.Labels, t int64, v []byte) (uint64, error) {
+	return 0, nil
+}
+
+func (a nopAppendable) AddFast(ref uint64, t int64, v []byte) error {
+	return nil
+}
+
+func (a nopAppendable) Commit() error {
+	return nil
+}
+
+func (a nopAppendable) Rollback() error {
+	return nil
+}
+
+type fakeScraper struct {
+	err error
+}
+
+func (s *fakeScraper) scrape(ctx context.Context, w io.Writer, profileType string) error {
+	if s.err != nil {
+		return s.err
+	}
+	_, err := w.Write([]byte("profile"))
+	return err
+}
+
+func (s *fakeScraper) offset(interval time.Duration) time.Duration {
+	return 0
+}
+
+func TestScrapeLoopReportsTargetState(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		err    error
+		health TargetHealth
+	}{
+		{name: "up", health: HealthGood},
+		{name: "down", err: errors.New("connection refused"), health: HealthBad},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			target := NewTarget(labels.FromStrings(ProfileName, "heap"), nil, nil)
+			require.Equal(t, HealthUnknown, target.Health())
+
+			sl := newScrapeLoop(context.Background(), target, &fakeScraper{err: c.err}, nil, nil, nopAppendable{})
+			go sl.run(time.Hour, time.Second, nil)
+			defer sl.stop()
+
+			require.Eventually(t, func() bool {
+				return !target.LastScrape().IsZero()
+			}, 5*time.Second, 10*time.Millisecond)
+
+			require.Equal(t, time.Hour, target.ScrapeInterval())
+			require.Equal(t, c.health, target.Health())
+			require.Equal(t, c.err, target.LastError())
+			require.True(t, target.LastScrapeDuration() >= 0)
+		})
+	}
+}
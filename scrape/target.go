@@ -55,6 +55,7 @@ type Target struct {
 	lastError          error
 	lastScrape         time.Time
 	lastScrapeDuration time.Duration
+	scrapeInterval     time.Duration
 	health             TargetHealth
 }
 
@@ -195,6 +196,37 @@ func (t *Target) LastScrapeDuration() time.Duration {
 	return t.lastScrapeDuration
 }
 
+// ScrapeInterval returns the interval the target is scraped at, it is zero
+// until a scrape loop was started for the target.
+func (t *Target) ScrapeInterval() time.Duration {
+	t.mtx.RLock()
+	defer t.mtx.RUnlock()
+
+	return t.scrapeInterval
+}
+
+func (t *Target) setScrapeInterval(interval time.Duration) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	t.scrapeInterval = interval
+}
+
+// report records the outcome of a scrape of the target.
+func (t *Target) report(start time.Time, dur time.Duration, err error) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if err == nil {
+		t.health = HealthGood
+	} else {
+		t.health = HealthBad
+	}
+	t.lastError = err
+	t.lastScrape = start
+	t.lastScrapeDuration = dur
+}
+
 // Health returns the last known health state of the target.
 func (t *Target) Health() TargetHealth {
 	t.mtx.RLock()
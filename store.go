@@ -47,6 +47,7 @@ func registerStorage(m map[string]setupFunc, app *kingpin.Application, name stri
 	storagePath := cmd.Flag("storage.tsdb.path", "Directory to read storage from.").
 		Default("./data").String()
 	retention := extkingpin.ModelDuration(cmd.Flag("storage.tsdb.retention.time", "How long to retain raw samples on local storage. 0d - disables this retention").Default("15d"))
+	labelValuesCacheTTL := extkingpin.ModelDuration(cmd.Flag("store.label-values-cache-ttl", "How long label values are cached before being recomputed from all blocks. 0s - disables the cache").Default("1m"))
 	grpcBindAddr, grpcGracePeriod, grpcCert, grpcKey, grpcClientCA := extkingpin.RegisterGRPCFlags(cmd)
 
 	m[name] = func(comp component.Component, g *run.Group, mux httpMux, probe prober.Probe, logger log.Logger, reg *prometheus.Registry, debugLogging bool) (prober.Probe, error) {
@@ -75,6 +76,7 @@ func registerStorage(m map[string]setupFunc, app *kingpin.Application, name stri
 			reg,
 			logger,
 			db,
+			time.Duration(*labelValuesCacheTTL),
 			*grpcBindAddr,
 			time.Duration(*grpcGracePeriod),
 			*grpcCert,
@@ -91,6 +93,7 @@ func runStorage(
 	reg *prometheus.Registry,
 	logger log.Logger,
 	db *tsdb.DB,
+	labelValuesCacheTTL time.Duration,
 	grpcBindAddr string,
 	grpcGracePeriod time.Duration,
 	grpcCert string,
@@ -104,7 +107,9 @@ func runStorage(
 		prober.NewInstrumentation(comp, logger, extprom.WrapRegistererWithPrefix("conprof_", reg)),
 	)
 	maxBytesPerFrame := 1024 * 1024 * 2 // 2 Mb default, might need to be tuned later on.
-	s := store.NewProfileStore(logger, db, maxBytesPerFrame)
+	s := store.NewProfileStore(logger, db, maxBytesPerFrame,
+		store.WithLabelValuesCache(reg, labelValuesCacheTTL),
+	)
 
 	srv := grpcserver.New(logger, reg, &opentracing.NoopTracer{}, comp, grpcProbe,
 		grpcserver.WithServer(store.RegisterReadableStoreServer(s)),